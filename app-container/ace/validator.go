@@ -19,9 +19,6 @@ The App Container Execution spec defines the following expectations within the e
 
 In addition, we validate:
  - The expected mount points are mounted
- - metadata service reachable at http://169.254.169.255
-
-TODO(jonboulle):
  - metadata service reachable at AC_METADATA_URL
 
 TODO(jonboulle):
@@ -35,6 +32,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -51,12 +49,17 @@ import (
 const (
 	standardPath    = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
 	appNameEnv      = "AC_APP_NAME"
-	metadataURLBase = "http://169.254.169.255/acMetadata/v1"
+	notifySocketEnv = "NOTIFY_SOCKET"
+	metadataURLEnv  = "AC_METADATA_URL"
+	metadataPath    = "/acMetadata/v1"
 
 	// marker files to validate
-	prestartFile = "/prestart"
-	mainFile     = "/main"
-	poststopFile = "/poststop"
+	prestartFile    = "/prestart"
+	mainFile        = "/main"
+	poststartFile   = "/poststart"
+	healthCheckFile = "/healthcheck"
+	prestopFile     = "/prestop"
+	poststopFile    = "/poststop"
 
 	mainVolFile     = "/db/main"
 	sidekickVolFile = "/db/sidekick"
@@ -90,7 +93,7 @@ type results []error
 // main outputs diagnostic information to stderr and exits 1 if validation fails
 func main() {
 	if len(os.Args) != 2 {
-		fmt.Fprintf(os.Stderr, "usage: %s [main|sidekick|preStart|postStop]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "usage: %s [main|sidekick|preStart|postStart|healthCheck|preStop|postStop]\n", os.Args[0])
 		os.Exit(64)
 	}
 	mode := os.Args[1]
@@ -102,6 +105,12 @@ func main() {
 		res = validateSidekick()
 	case "prestart":
 		res = validatePrestart()
+	case "poststart":
+		res = validatePoststart()
+	case "healthcheck":
+		res = validateHealthCheck()
+	case "prestop":
+		res = validatePrestop()
 	case "poststop":
 		res = validatePoststop()
 	default:
@@ -122,20 +131,25 @@ func main() {
 func validateMain() (errs results) {
 	errs = append(errs, assertExists(prestartFile)...)
 	errs = append(errs, assertNotExistsAndCreate(mainFile)...)
+	errs = append(errs, assertNotExists(prestopFile)...)
 	errs = append(errs, assertNotExists(poststopFile)...)
 	errs = append(errs, ValidatePath(standardPath)...)
 	errs = append(errs, ValidateEnvironment(env)...)
 	errs = append(errs, ValidateMountpoints(mps)...)
 	errs = append(errs, ValidateAppNameEnv(an)...)
 	errs = append(errs, ValidateMetadataSvc()...)
-	errs = append(errs, waitForFile(sidekickVolFile, timeout)...)
 	errs = append(errs, assertNotExistsAndCreate(mainVolFile)...)
+	// sidekick starts once main is ready
+	errs = append(errs, notifyReady()...)
+	errs = append(errs, waitForFile(sidekickVolFile, timeout)...)
+	errs = append(errs, waitForFile(poststartFile, timeout)...)
+	errs = append(errs, waitForFile(healthCheckFile, timeout)...)
 	return
 }
 
 func validateSidekick() (errs results) {
+	errs = append(errs, assertExists(mainVolFile)...)
 	errs = append(errs, assertNotExistsAndCreate(sidekickVolFile)...)
-	errs = append(errs, waitForFile(mainVolFile, timeout)...)
 	return
 }
 
@@ -146,9 +160,37 @@ func validatePrestart() (errs results) {
 	return
 }
 
+func validatePoststart() (errs results) {
+	errs = append(errs, assertExists(prestartFile)...)
+	errs = append(errs, assertNotExistsAndCreate(poststartFile)...)
+	errs = append(errs, assertNotExists(prestopFile)...)
+	errs = append(errs, assertNotExists(poststopFile)...)
+	return
+}
+
+// validateHealthCheck runs every interval while main is running, so it can
+// run several times
+func validateHealthCheck() (errs results) {
+	errs = append(errs, assertExists(prestartFile)...)
+	errs = append(errs, assertNotExists(poststopFile)...)
+	if err := touchFile(healthCheckFile); err != nil {
+		errs = append(errs, fmt.Errorf("error touching file %q: %v", healthCheckFile, err))
+	}
+	return
+}
+
+func validatePrestop() (errs results) {
+	errs = append(errs, assertExists(mainFile)...)
+	errs = append(errs, assertExists(poststartFile)...)
+	errs = append(errs, assertNotExistsAndCreate(prestopFile)...)
+	errs = append(errs, assertNotExists(poststopFile)...)
+	return
+}
+
 func validatePoststop() (errs results) {
 	errs = append(errs, assertExists(prestartFile)...)
 	errs = append(errs, assertExists(mainFile)...)
+	errs = append(errs, assertExists(prestopFile)...)
 	errs = append(errs, assertNotExistsAndCreate(poststopFile)...)
 	return
 }
@@ -179,7 +221,7 @@ func ValidateEnvironment(wenv map[string]string) (r results) {
 		k := parts[0]
 		_, ok := wenv[k]
 		switch {
-		case k == appNameEnv, k == "PATH", k == "TERM":
+		case k == appNameEnv, k == notifySocketEnv, k == metadataURLEnv, k == "PATH", k == "TERM":
 		case !ok:
 			r = append(r, fmt.Errorf("unexpected environment variable %q set", k))
 		}
@@ -187,6 +229,24 @@ func ValidateEnvironment(wenv map[string]string) (r results) {
 	return
 }
 
+// notifyReady notifies the ACE that the app is ready (cf. sd_notify(3)), if
+// it is waiting for it
+func notifyReady() (r results) {
+	addr := os.Getenv(notifySocketEnv)
+	if addr == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return append(r, fmt.Errorf("error connecting to %s: %v", notifySocketEnv, err))
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("READY=1")); err != nil {
+		r = append(r, fmt.Errorf("error notifying readiness: %v", err))
+	}
+	return
+}
+
 // ValidateAppNameEnv ensures that the environment variable specifying the
 // entrypoint of this process is set correctly.
 func ValidateAppNameEnv(want string) (r results) {
@@ -233,8 +293,14 @@ func metadataRequest(req *http.Request) ([]byte, error) {
 	return body, nil
 }
 
+// metadataURLBase returns the base URL of the metadata service given by the
+// ACE
+func metadataURLBase() string {
+	return strings.TrimSuffix(os.Getenv(metadataURLEnv), "/") + metadataPath
+}
+
 func metadataGet(path string) ([]byte, error) {
-	req, err := http.NewRequest("GET", metadataURLBase+path, nil)
+	req, err := http.NewRequest("GET", metadataURLBase()+path, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -242,7 +308,7 @@ func metadataGet(path string) ([]byte, error) {
 }
 
 func metadataPost(path string, body []byte) ([]byte, error) {
-	req, err := http.NewRequest("POST", metadataURLBase+path, bytes.NewBuffer(body))
+	req, err := http.NewRequest("POST", metadataURLBase()+path, bytes.NewBuffer(body))
 	if err != nil {
 		panic(err)
 	}
@@ -252,7 +318,7 @@ func metadataPost(path string, body []byte) ([]byte, error) {
 }
 
 func metadataPostForm(path string, data url.Values) ([]byte, error) {
-	req, err := http.NewRequest("POST", metadataURLBase+path, strings.NewReader(data.Encode()))
+	req, err := http.NewRequest("POST", metadataURLBase()+path, strings.NewReader(data.Encode()))
 	if err != nil {
 		panic(err)
 	}
@@ -409,9 +475,15 @@ func validateSigning(crm *schema.ContainerRuntimeManifest) results {
 	return r
 }
 
+// ValidateMetadataSvc ensures that the metadata service is reachable at
+// AC_METADATA_URL and serves the metadata of this container
 func ValidateMetadataSvc() results {
 	r := results{}
 
+	if os.Getenv(metadataURLEnv) == "" {
+		return append(r, fmt.Errorf("%s not set", metadataURLEnv))
+	}
+
 	cm, err := metadataGet("/container/manifest")
 	if err != nil {
 		return append(r, err)
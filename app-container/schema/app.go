@@ -3,6 +3,7 @@ package schema
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/coreos/rocket/app-container/schema/types"
 )
@@ -77,6 +78,13 @@ func (am *AppManifest) assertValid() error {
 	if len(am.Exec) < 1 {
 		return errors.New(`Exec cannot be empty`)
 	}
+	ehs := make(map[string]bool)
+	for _, eh := range am.EventHandlers {
+		if ehs[eh.Name] {
+			return fmt.Errorf(`Only one eventHandler of name %q allowed`, eh.Name)
+		}
+		ehs[eh.Name] = true
+	}
 	// TODO(jonboulle): assert hashes is not empty?
 	return nil
 }
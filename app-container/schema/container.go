@@ -2,6 +2,7 @@ package schema
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/coreos/rocket/app-container/schema/types"
 )
@@ -50,7 +51,17 @@ func (cm *ContainerRuntimeManifest) assertValid() error {
 	if cm.ACKind != "ContainerRuntimeManifest" {
 		return types.ACKindError(`missing or bad ACKind (must be "ContainerRuntimeManifest")`)
 	}
-	return nil
+	for _, a := range cm.Apps {
+		for _, d := range a.After {
+			if d.App.Equals(a.Name) {
+				return fmt.Errorf("app %q cannot start after itself", a.Name)
+			}
+			if cm.Apps.Get(d.App) == nil {
+				return fmt.Errorf("app %q starts after unknown app %q", a.Name, d.App)
+			}
+		}
+	}
+	return cm.Apps.assertNoDependencyCycle()
 }
 
 type AppList []App
@@ -68,10 +79,77 @@ func (al AppList) Get(name types.ACName) *App {
 	return nil
 }
 
+// assertNoDependencyCycle returns an error if some apps of the AppList
+// (transitively) start after each other, which would keep them from starting
+func (al AppList) assertNoDependencyCycle() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(a *App) error
+	visit = func(a *App) error {
+		name := a.Name.String()
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("app %q (transitively) starts after itself", a.Name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, d := range a.After {
+			if da := al.Get(d.App); da != nil {
+				if err := visit(da); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for i := range al {
+		if err := visit(&al[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // App describes an application referenced in a ContainerRuntimeManifest
 type App struct {
-	Name        types.ACName            `json:"name"`
-	ImageID     types.Hash              `json:"imageID"`
-	Isolators   []types.Isolator        `json:"isolators"`
-	Annotations map[types.ACName]string `json:"annotations"`
+	Name          types.ACName            `json:"name"`
+	ImageID       types.Hash              `json:"imageID"`
+	Exec          []string                `json:"exec,omitempty"`
+	Environment   map[string]string       `json:"environment,omitempty"`
+	User          string                  `json:"user,omitempty"`
+	Group         string                  `json:"group,omitempty"`
+	RestartPolicy *types.RestartPolicy    `json:"restartPolicy,omitempty"`
+	After         []types.AppDependency   `json:"after,omitempty"`
+	Isolators     []types.Isolator        `json:"isolators"`
+	Annotations   map[types.ACName]string `json:"annotations"`
+}
+
+// ApplyTo returns a copy of the given AppManifest (the manifest of the image
+// of the app) with the overrides of the app applied: its exec and user/group
+// replace the ones of the image if set, and its environment is appended to
+// the one of the image.
+func (a App) ApplyTo(am AppManifest) *AppManifest {
+	if len(a.Exec) > 0 {
+		am.Exec = a.Exec
+	}
+	env := make(map[string]string)
+	for k, v := range am.Environment {
+		env[k] = v
+	}
+	for k, v := range a.Environment {
+		env[k] = v
+	}
+	am.Environment = env
+	if a.User != "" {
+		am.User = a.User
+	}
+	if a.Group != "" {
+		am.Group = a.Group
+	}
+	return &am
 }
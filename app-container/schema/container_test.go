@@ -0,0 +1,71 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// crmWithApps returns a container runtime manifest with the given apps, in
+// JSON
+func crmWithApps(apps string) string {
+	return `{
+		"acVersion": "0.1.0",
+		"acKind": "ContainerRuntimeManifest",
+		"uuid": "6733c088-a507-4694-aabf-edbe4fc5266f",
+		"apps": [` + apps + `]
+	}`
+}
+
+func testApp(name string, after ...string) string {
+	var deps []string
+	for _, a := range after {
+		deps = append(deps, `{"app": "`+a+`"}`)
+	}
+	return `{
+		"name": "` + name + `",
+		"imageID": "sha256-0000000000000000000000000000000000000000000000000000000000000000",
+		"after": [` + strings.Join(deps, ",") + `]
+	}`
+}
+
+func TestContainerRuntimeManifestDependencies(t *testing.T) {
+	tests := []struct {
+		apps []string
+		err  string
+	}{
+		{[]string{testApp("a")}, ""},
+		{[]string{testApp("a"), testApp("b", "a"), testApp("c", "a", "b")}, ""},
+		// the order of the apps does not matter
+		{[]string{testApp("b", "a"), testApp("a")}, ""},
+		{[]string{testApp("a", "a")}, "after itself"},
+		{[]string{testApp("a", "z")}, "unknown app"},
+		{[]string{testApp("a", "b"), testApp("b", "a")}, "starts after itself"},
+		{[]string{testApp("a", "c"), testApp("b", "a"), testApp("c", "b"), testApp("d")}, "starts after itself"},
+	}
+	for i, tt := range tests {
+		var crm ContainerRuntimeManifest
+		err := json.Unmarshal([]byte(crmWithApps(strings.Join(tt.apps, ","))), &crm)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("#%d: expected error containing %q, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestContainerRuntimeManifestMarshalDependencies(t *testing.T) {
+	var crm ContainerRuntimeManifest
+	if err := json.Unmarshal([]byte(crmWithApps(testApp("a")+","+testApp("b", "a"))), &crm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// an invalid manifest is not written either
+	crm.Apps[0].After = crm.Apps[1].After
+	if _, err := json.Marshal(crm); err == nil {
+		t.Errorf("expected error marshalling an app starting after itself")
+	}
+}
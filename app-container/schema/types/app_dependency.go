@@ -0,0 +1,13 @@
+package types
+
+// AppDependency declares that an app of a container starts after another app
+// of the same container, which it requires to run: it is stopped when the app
+// depended on stops
+type AppDependency struct {
+	// App is the name of the app depended on
+	App ACName `json:"app"`
+	// Ready delays the start of the app until the app depended on has
+	// notified that it is ready (cf. sd_notify(3)), rather than only until
+	// it has started
+	Ready bool `json:"ready,omitempty"`
+}
@@ -4,11 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 )
 
 type EventHandler struct {
 	Name string   `json:"name"`
 	Exec []string `json:"exec"`
+
+	// Interval and OnFailure only apply to health-check handlers
+	Interval  string `json:"interval,omitempty"`
+	OnFailure string `json:"onFailure,omitempty"`
 }
 
 type eventHandler EventHandler
@@ -16,8 +21,21 @@ type eventHandler EventHandler
 func (e EventHandler) assertValid() error {
 	s := e.Name
 	switch s {
-	case "pre-start", "post-stop":
+	case "pre-start", "post-start", "pre-stop", "post-stop":
+		if e.Interval != "" || e.OnFailure != "" {
+			return fmt.Errorf(`eventHandler %q cannot have an "interval" or "onFailure"`, s)
+		}
 		return nil
+	case "health-check":
+		if _, err := e.IntervalDuration(); err != nil {
+			return err
+		}
+		switch e.OnFailure {
+		case "", "restart", "fail":
+			return nil
+		default:
+			return fmt.Errorf(`bad eventHandler "onFailure": %q`, e.OnFailure)
+		}
 	case "":
 		return errors.New(`eventHandler "name" cannot be empty`)
 	default:
@@ -25,6 +43,22 @@ func (e EventHandler) assertValid() error {
 	}
 }
 
+// IntervalDuration returns the interval at which a health-check handler is
+// run
+func (e EventHandler) IntervalDuration() (time.Duration, error) {
+	if e.Interval == "" {
+		return 0, errors.New(`health-check eventHandler must have an "interval"`)
+	}
+	d, err := time.ParseDuration(e.Interval)
+	if err != nil {
+		return 0, fmt.Errorf(`bad eventHandler "interval": %v`, err)
+	}
+	if d < time.Millisecond {
+		return 0, fmt.Errorf(`bad eventHandler "interval": %q is less than 1ms`, e.Interval)
+	}
+	return d, nil
+}
+
 func (e EventHandler) MarshalJSON() ([]byte, error) {
 	if err := e.assertValid(); err != nil {
 		return nil, err
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventHandlerUnmarshal(t *testing.T) {
+	tests := []string{
+		`{"name": "pre-start", "exec": ["/bin/true"]}`,
+		`{"name": "post-start", "exec": ["/bin/true"]}`,
+		`{"name": "pre-stop", "exec": ["/bin/true"]}`,
+		`{"name": "post-stop", "exec": ["/bin/true"]}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "30s"}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "1m", "onFailure": "restart"}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "500ms", "onFailure": "fail"}`,
+	}
+	for i, in := range tests {
+		var eh EventHandler
+		if err := json.Unmarshal([]byte(in), &eh); err != nil {
+			t.Errorf("#%d: got err=%v, want nil", i, err)
+		}
+	}
+}
+
+func TestEventHandlerUnmarshalBad(t *testing.T) {
+	tests := []string{
+		`{"name": "", "exec": ["/bin/true"]}`,
+		`{"name": "post-restart", "exec": ["/bin/true"]}`,
+		`{"name": "pre-start", "exec": ["/bin/true"], "interval": "30s"}`,
+		`{"name": "post-stop", "exec": ["/bin/true"], "onFailure": "fail"}`,
+		`{"name": "health-check", "exec": ["/bin/true"]}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "often"}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "0s"}`,
+		`{"name": "health-check", "exec": ["/bin/true"], "interval": "30s", "onFailure": "ignore"}`,
+	}
+	for i, in := range tests {
+		var eh EventHandler
+		if err := json.Unmarshal([]byte(in), &eh); err == nil {
+			t.Errorf("#%d: got err=nil, want non-nil", i)
+		}
+	}
+}
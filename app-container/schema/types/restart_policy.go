@@ -0,0 +1,95 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RestartPolicy defines whether an app is restarted once it has exited
+type RestartPolicy struct {
+	// Policy is one of "never", "on-failure" or "always"
+	Policy string `json:"policy"`
+	// MaxAttempts is the number of times an "on-failure" app is restarted
+	// before giving up; 0 means no limit
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Backoff is how long to wait before restarting the app (a duration
+	// such as "10s")
+	Backoff string `json:"backoff,omitempty"`
+}
+
+type restartPolicy RestartPolicy
+
+// NewRestartPolicy returns a valid RestartPolicy, or an error if the given
+// settings do not make one
+func NewRestartPolicy(policy string, maxAttempts int, backoff string) (*RestartPolicy, error) {
+	rp := RestartPolicy{
+		Policy:      policy,
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+	}
+	if err := rp.assertValid(); err != nil {
+		return nil, err
+	}
+	return &rp, nil
+}
+
+func (rp RestartPolicy) assertValid() error {
+	switch rp.Policy {
+	case "never":
+		if rp.Backoff != "" {
+			return errors.New(`restartPolicy "never" cannot have a "backoff"`)
+		}
+	case "on-failure", "always":
+	case "":
+		return errors.New(`restartPolicy "policy" cannot be empty`)
+	default:
+		return fmt.Errorf(`bad restartPolicy "policy": %q`, rp.Policy)
+	}
+	if rp.MaxAttempts < 0 {
+		return fmt.Errorf(`bad restartPolicy "maxAttempts": %d`, rp.MaxAttempts)
+	}
+	if rp.MaxAttempts > 0 && rp.Policy != "on-failure" {
+		return fmt.Errorf(`restartPolicy %q cannot have "maxAttempts"`, rp.Policy)
+	}
+	if _, err := rp.BackoffDuration(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// BackoffDuration returns how long to wait before restarting the app
+func (rp RestartPolicy) BackoffDuration() (time.Duration, error) {
+	if rp.Backoff == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(rp.Backoff)
+	if err != nil {
+		return 0, fmt.Errorf(`bad restartPolicy "backoff": %v`, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf(`bad restartPolicy "backoff": %q is negative`, rp.Backoff)
+	}
+	return d, nil
+}
+
+func (rp RestartPolicy) MarshalJSON() ([]byte, error) {
+	if err := rp.assertValid(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(restartPolicy(rp))
+}
+
+func (rp *RestartPolicy) UnmarshalJSON(data []byte) error {
+	var jrp restartPolicy
+	if err := json.Unmarshal(data, &jrp); err != nil {
+		return err
+	}
+	nrp := RestartPolicy(jrp)
+	if err := nrp.assertValid(); err != nil {
+		return err
+	}
+	*rp = nrp
+	return nil
+}
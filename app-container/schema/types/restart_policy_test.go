@@ -0,0 +1,43 @@
+package types
+
+import "testing"
+
+func TestNewRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy      string
+		maxAttempts int
+		backoff     string
+	}{
+		{"never", 0, ""},
+		{"on-failure", 0, ""},
+		{"on-failure", 5, "10s"},
+		{"always", 0, "500ms"},
+	}
+	for i, tt := range tests {
+		if _, err := NewRestartPolicy(tt.policy, tt.maxAttempts, tt.backoff); err != nil {
+			t.Errorf("#%d: got err=%v, want nil", i, err)
+		}
+	}
+}
+
+func TestNewRestartPolicyBad(t *testing.T) {
+	tests := []struct {
+		policy      string
+		maxAttempts int
+		backoff     string
+	}{
+		{"", 0, ""},
+		{"sometimes", 0, ""},
+		{"never", 0, "10s"},
+		{"never", 3, ""},
+		{"always", 3, ""},
+		{"on-failure", -1, ""},
+		{"on-failure", 0, "soon"},
+		{"on-failure", 0, "-1s"},
+	}
+	for i, tt := range tests {
+		if rp, err := NewRestartPolicy(tt.policy, tt.maxAttempts, tt.backoff); err == nil {
+			t.Errorf("#%d: got %v, want error", i, rp)
+		}
+	}
+}
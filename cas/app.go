@@ -0,0 +1,78 @@
+package cas
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// App indexes the app images of the store by app name: it points to the last
+// image stored with an app manifest of that name
+type App struct {
+	Name string
+	Blob string
+}
+
+func NewApp(name string) *App {
+	return &App{Name: name}
+}
+
+func (a App) Marshal() []byte {
+	m, _ := json.Marshal(a)
+	return m
+}
+
+func (a *App) Unmarshal(data []byte) {
+	err := json.Unmarshal(data, a)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (a App) Hash() string {
+	return types.NewHashSHA256([]byte(a.Name)).String()
+}
+
+func (a App) Type() int64 {
+	return appType
+}
+
+// indexApp records the image stored under key in the app index, if it is an
+// app image
+func (ds Store) indexApp(key string) error {
+	rs, err := ds.ReadStream(key)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	tr := tar.NewReader(rs)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// not an app image
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filepath.Clean(hdr.Name) != "app" {
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		var am schema.AppManifest
+		if err := am.UnmarshalJSON(b); err != nil {
+			return err
+		}
+		ds.WriteIndex(&App{Name: am.Name.String(), Blob: key})
+		return nil
+	}
+}
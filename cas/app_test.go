@@ -0,0 +1,86 @@
+package cas
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// testImage returns an image holding the given files
+func testImage(t *testing.T, files map[string]string) *bytes.Buffer {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for name, data := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("error writing image: %v", err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatalf("error writing image: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	return &b
+}
+
+// tmpKey returns a temporary key for the image named name, the way rkt does
+func tmpKey(name string) string {
+	return types.NewHashSHA256([]byte(name)).String()
+}
+
+func testAppManifest(name, exec string) string {
+	return `{"acKind": "AppManifest", "acVersion": "0.1.0", "name": "` + name +
+		`", "os": "linux", "arch": "amd64", "exec": ["` + exec + `"]}`
+}
+
+func TestIndexApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-cas")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
+
+	a := NewApp("example.com/app")
+	if err := ds.ReadIndex(a); err == nil {
+		t.Fatalf("app found in empty store")
+	}
+
+	key, err := ds.WriteACI(tmpKey("1"), testImage(t, map[string]string{
+		"app":           testAppManifest("example.com/app", "/app"),
+		"rootfs/README": "an app",
+	}))
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	a = NewApp("example.com/app")
+	if err := ds.ReadIndex(a); err != nil || a.Blob != key {
+		t.Errorf("app not indexed: got %q, %v, want %q", a.Blob, err, key)
+	}
+
+	// the last image stored wins
+	key, err = ds.WriteACI(tmpKey("2"), testImage(t, map[string]string{
+		"app": testAppManifest("example.com/app", "/app2"),
+	}))
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	a = NewApp("example.com/app")
+	if err := ds.ReadIndex(a); err != nil || a.Blob != key {
+		t.Errorf("app not reindexed: got %q, %v, want %q", a.Blob, err, key)
+	}
+
+	// images without an app manifest are stored but not indexed
+	if _, err := ds.WriteACI(tmpKey("3"), testImage(t, map[string]string{"rootfs/README": "no app"})); err != nil {
+		t.Errorf("unexpected error writing image without app manifest: %v", err)
+	}
+	if _, err := ds.WriteACI(tmpKey("4"), testImage(t, map[string]string{"app": "{}"})); err == nil {
+		t.Errorf("expected error writing image with bad app manifest")
+	}
+}
@@ -17,12 +17,14 @@ const (
 	blobType int64 = iota
 	remoteType
 	tmpType
+	appType
 )
 
 var otmap = [...]string{
 	"blob",
 	"remote",
 	"tmp",
+	"app",
 }
 
 type Store struct {
@@ -112,6 +114,11 @@ func (ds Store) WriteACI(tmpKey string, orig io.Reader) (string, error) {
 
 	ds.stores[tmpType].Erase(tmpKey)
 
+	// images can then be found by app name (cf. App)
+	if err := ds.indexApp(key); err != nil {
+		return "", fmt.Errorf("error indexing image: %v", err)
+	}
+
 	return key, nil
 }
 
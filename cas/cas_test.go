@@ -2,17 +2,26 @@ package cas
 
 import (
 	"encoding/base64"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
+
+	"github.com/coreos/rocket/app-container/schema/types"
 )
 
 func TestObjectStore(t *testing.T) {
-	ds := NewStore(".")
+	dir, err := ioutil.TempDir("", "rkt-cas")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
 	for _, valueStr := range []string{
 		"I am a manually placed object",
 	} {
-		ds.stores[objectType].Write(sha256sum(valueStr), []byte(valueStr))
+		ds.stores[blobType].Write(types.NewHashSHA256([]byte(valueStr)).String(), []byte(valueStr))
 	}
 
 	ds.Dump(false)
@@ -35,7 +44,12 @@ func TestDownloading(t *testing.T) {
 		{Remote{ts.URL, []string{}, "12", "96609004016e9625763c7153b74120c309c8cb1bd794345bf6fa2e60ac001cd7"}, body, true},
 	}
 
-	ds := NewStore(".")
+	dir, err := ioutil.TempDir("", "rkt-cas")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := NewStore(dir)
 
 	for _, tt := range tests {
 		_, err := ds.stores[remoteType].Read(tt.r.Hash())
@@ -6,6 +6,7 @@ package path
 //
 
 import (
+	"fmt"
 	"path/filepath"
 
 	"github.com/coreos/rocket/app-container/schema/types"
@@ -14,6 +15,15 @@ import (
 const (
 	Stage1Dir = "/stage1"
 	stage2Dir = "/opt/stage2"
+	statusDir = "/rkt/status"
+	envDir    = "/rkt/env"
+	// journalDir is where journald keeps persistent journals
+	journalDir = "/var/log/journal"
+
+	// MetadataSvcRegSock is the Unix socket on which the metadata service
+	// accepts the registration of containers. Containers cannot reach it,
+	// unlike the address the service serves the metadata on.
+	MetadataSvcRegSock = "/run/rkt/metadata-svc.sock"
 )
 
 // Stage1RootfsPath returns the directory in root containing the rootfs for stage1
@@ -21,37 +31,181 @@ func Stage1RootfsPath(root string) string {
 	return filepath.Join(root, Stage1Dir)
 }
 
+// Stage1ManifestPath returns the path in root to the app manifest of the
+// stage1 image, which declares the entrypoint of stage1
+func Stage1ManifestPath(root string) string {
+	return filepath.Join(root, "stage1-manifest")
+}
+
+// Stage1IDPath returns the path in root to the file recording the hash of the
+// stage1 image the container runs with
+func Stage1IDPath(root string) string {
+	return filepath.Join(root, "stage1-id")
+}
+
+// Stage1ImagePath returns the directory in root holding a private copy of the
+// stage1 image, which the apps cannot write to, unlike the stage1 rootfs
+func Stage1ImagePath(root string) string {
+	return filepath.Join(root, "stage1-image")
+}
+
 // ContainerManifestPath returns the path in root to the Container Runtime Manifest
 func ContainerManifestPath(root string) string {
 	return filepath.Join(root, "container")
 }
 
+// NetInfoPath returns the path in root to the file describing the
+// container's private network, if it has one
+func NetInfoPath(root string) string {
+	return filepath.Join(root, "net-info.json")
+}
+
+// PidPath returns the path in root to the file holding the PID of the
+// process running the container (e.g. systemd-nspawn)
+func PidPath(root string) string {
+	return filepath.Join(root, "pid")
+}
+
+// PrivateUsersPath returns the path in root to the file recording the uid
+// range the container's user namespace is mapped to, if it has one
+func PrivateUsersPath(root string) string {
+	return filepath.Join(root, "private-users")
+}
+
+// LogTargetPath returns the path in root to the file recording where the
+// logs of the apps are kept: "files" or "journal"
+func LogTargetPath(root string) string {
+	return filepath.Join(root, "log-target")
+}
+
+// InitArgsPath returns the path in root to the file holding the arguments the
+// stage1 init runs the container with, NUL-separated
+func InitArgsPath(root string) string {
+	return filepath.Join(root, "init-args")
+}
+
+// StartedPath returns the path in root to the file stage0 creates once it has
+// locked the container directory to run the container, whatever its stage1
+func StartedPath(root string) string {
+	return filepath.Join(root, "started")
+}
+
+// UnitPath returns the path in root to the file recording the name of the
+// host systemd unit a detached container runs as, if it does
+func UnitPath(root string) string {
+	return filepath.Join(root, "unit")
+}
+
+// OutputPath returns the path in root to the file the output of a detached
+// container that does not run as a systemd unit is written to
+func OutputPath(root string) string {
+	return filepath.Join(root, "output")
+}
+
+// JournalPath returns the directory in root holding the journal files of the
+// container when its logs are kept in files
+func JournalPath(root string) string {
+	return filepath.Join(Stage1RootfsPath(root), journalDir)
+}
+
+// HostJournalPath returns the directory of the host journal holding the
+// journal files of the container with the given UUID (its machine ID) when
+// its logs are forwarded to the host journal
+func HostJournalPath(cuuid types.UUID) string {
+	return filepath.Join(journalDir, fmt.Sprintf("%x", cuuid[:]))
+}
+
+// StatusDirPath returns the directory in root holding the exit statuses of
+// the apps
+func StatusDirPath(root string) string {
+	return filepath.Join(Stage1RootfsPath(root), statusDir)
+}
+
+// AppStatusPath returns the path in root to the file the exit status of an
+// app is written to once it has exited.
+func AppStatusPath(root string, appName types.ACName) string {
+	return filepath.Join(StatusDirPath(root), EscapedAppName(appName))
+}
+
+// EnvDirPath returns the directory in root holding the environments of the
+// apps
+func EnvDirPath(root string) string {
+	return filepath.Join(Stage1RootfsPath(root), envDir)
+}
+
+// AppEnvPath returns the path in root to the file holding the environment of
+// an app, as NUL-separated NAME=VALUE entries (cf. /proc/PID/environ).
+func AppEnvPath(root string, appName types.ACName) string {
+	return filepath.Join(EnvDirPath(root), EscapedAppName(appName))
+}
+
+// Stage2Path returns the directory in root under which the app images are
+// extracted
+func Stage2Path(root string) string {
+	return filepath.Join(root, Stage1Dir, stage2Dir)
+}
+
 // AppImagePath returns the path where an app image (i.e. RAF) is rooted (i.e.
-// where its contents are extracted during stage0), based on the app image ID.
-func AppImagePath(root string, imageID types.Hash) string {
-	return filepath.Join(root, Stage1Dir, stage2Dir, imageID.String())
+// where its contents are extracted during stage0), based on the app name.
+func AppImagePath(root string, appName types.ACName) string {
+	return filepath.Join(Stage2Path(root), EscapedAppName(appName))
 }
 
 // AppRootfsPath returns the path to an app's rootfs.
-// imageID should be the app image ID.
-func AppRootfsPath(root string, imageID types.Hash) string {
-	return filepath.Join(AppImagePath(root, imageID), "rootfs")
+func AppRootfsPath(root string, appName types.ACName) string {
+	return filepath.Join(AppImagePath(root, appName), "rootfs")
 }
 
 // RelAppImagePath returns the path of an application image relative to the
 // stage1 chroot
-func RelAppImagePath(imageID types.Hash) string {
-	return filepath.Join(stage2Dir, imageID.String())
+func RelAppImagePath(appName types.ACName) string {
+	return filepath.Join(stage2Dir, EscapedAppName(appName))
 }
 
-// RelAppImagePath returns the path of an application's rootfs relative to the
+// RelAppRootfsPath returns the path of an application's rootfs relative to the
 // stage1 chroot
-func RelAppRootfsPath(imageID types.Hash) string {
-	return filepath.Join(RelAppImagePath(imageID), "rootfs")
+func RelAppRootfsPath(appName types.ACName) string {
+	return filepath.Join(RelAppImagePath(appName), "rootfs")
+}
+
+// RelAppStatusPath returns the path of the exit status file of an app
+// relative to the stage1 chroot
+func RelAppStatusPath(appName types.ACName) string {
+	return filepath.Join(statusDir, EscapedAppName(appName))
+}
+
+// RelAppEnvPath returns the path of the environment file of an app relative
+// to the stage1 chroot
+func RelAppEnvPath(appName types.ACName) string {
+	return filepath.Join(envDir, EscapedAppName(appName))
 }
 
 // AppManifestPath returns the path to the app's manifest file inside the expanded ACI.
-// id should be the app image ID.
-func AppManifestPath(root string, imageID types.Hash) string {
-	return filepath.Join(AppImagePath(root, imageID), "app")
+func AppManifestPath(root string, appName types.ACName) string {
+	return filepath.Join(AppImagePath(root, appName), "app")
+}
+
+// EscapedAppName returns the name of an app escaped the way systemd escapes
+// unit names (cf. systemd-escape(1)): "/" becomes "-", and any character but
+// ASCII alphanumerics, ":", "_" and "." (except a leading ".") is replaced by
+// its C-style "\xNN" escape. The result identifies the app within its
+// container and is usable both as a unit name prefix and a path component.
+func EscapedAppName(appName types.ACName) string {
+	s := appName.String()
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '/':
+			b = append(b, '-')
+		case c == '.' && i == 0:
+			b = append(b, fmt.Sprintf(`\x%02x`, c)...)
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == ':', c == '_', c == '.':
+			b = append(b, c)
+		default:
+			b = append(b, fmt.Sprintf(`\x%02x`, c)...)
+		}
+	}
+	return string(b)
 }
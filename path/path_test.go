@@ -0,0 +1,37 @@
+package path
+
+import (
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+func TestEscapedAppName(t *testing.T) {
+	tests := []struct {
+		in   types.ACName
+		want string
+	}{
+		{"etcd", "etcd"},
+		{"coreos.com/etcd", "coreos.com-etcd"},
+		{"coreos.com/ace-validator-main", `coreos.com-ace\x2dvalidator\x2dmain`},
+		{".hidden/app", `\x2ehidden-app`},
+		{"a//b/", "a--b-"},
+		{"MixedCase", "MixedCase"},
+	}
+	for i, tt := range tests {
+		if got := EscapedAppName(tt.in); got != tt.want {
+			t.Errorf("#%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestHostJournalPath(t *testing.T) {
+	u, err := types.NewUUID("6733C088-A507-4694-AABF-EDBE4FC5266F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/var/log/journal/6733c088a5074694aabfedbe4fc5266f"
+	if got := HostJournalPath(*u); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+// Package journal sends entries to the systemd journal over its native
+// protocol, which, unlike the standard output of services, lets the entries
+// carry fields of their own
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// SocketPath is where journald receives entries
+const SocketPath = "/run/systemd/journal/socket"
+
+// Logger sends messages to the journal, along with a set of fields
+type Logger struct {
+	conn   *net.UnixConn
+	fields map[string]string
+}
+
+// Dial returns a logger sending its messages to the journal listening on the
+// socket at path, with the given fields, by name, attached to them
+func Dial(path string, fields map[string]string) (*Logger, error) {
+	for k := range fields {
+		if !validFieldName(k) {
+			return nil, fmt.Errorf("invalid journal field name %q", k)
+		}
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to journal: %v", err)
+	}
+	return &Logger{conn: conn, fields: fields}, nil
+}
+
+// Log sends msg to the journal, at the given syslog priority
+func (l *Logger) Log(msg string, priority int) error {
+	fields := map[string]string{
+		"MESSAGE":  msg,
+		"PRIORITY": fmt.Sprint(priority),
+	}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	if _, err := l.conn.Write(encode(fields)); err != nil {
+		return fmt.Errorf("error writing to journal: %v", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the journal
+func (l *Logger) Close() error {
+	return l.conn.Close()
+}
+
+// encode returns the entry made of the given fields, in the order of their
+// names. Values holding newlines are length-prefixed, the others are written
+// as NAME=VALUE lines.
+func encode(fields map[string]string) []byte {
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		v := fields[k]
+		if !strings.Contains(v, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", k, v)
+			continue
+		}
+		b.WriteString(k + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(v)))
+		b.WriteString(v + "\n")
+	}
+	return b.Bytes()
+}
+
+// validFieldName reports whether name can be the name of a field set by a
+// client: uppercase letters, digits and underscores, not starting with an
+// underscore, which marks the fields set by journald itself
+func validFieldName(name string) bool {
+	if name == "" || name[0] == '_' {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
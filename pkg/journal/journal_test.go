@@ -0,0 +1,74 @@
+package journal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{
+			map[string]string{"MESSAGE": "hello", "APP_NAME": "example.com/web"},
+			"APP_NAME=example.com/web\nMESSAGE=hello\n",
+		},
+		{
+			map[string]string{"MESSAGE": "a\nb"},
+			"MESSAGE\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n",
+		},
+		{
+			map[string]string{"MESSAGE": ""},
+			"MESSAGE=\n",
+		},
+	}
+	for i, tt := range tests {
+		if got := string(encode(tt.fields)); got != tt.want {
+			t.Errorf("#%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-journal")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "socket")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+
+	if _, err := Dial(sock, map[string]string{"_PID": "1"}); err == nil {
+		t.Errorf("expected trusted field to be refused")
+	}
+	if _, err := Dial(sock, map[string]string{"app": "web"}); err == nil {
+		t.Errorf("expected lowercase field to be refused")
+	}
+
+	l, err := Dial(sock, map[string]string{"CONTAINER_UUID": "6733c088-a507-4694-aabf-edbe4fc5266f"})
+	if err != nil {
+		t.Fatalf("error dialing journal: %v", err)
+	}
+	defer l.Close()
+	if err := l.Log("hello", 6); err != nil {
+		t.Fatalf("error logging: %v", err)
+	}
+	b := make([]byte, 1024)
+	n, err := ln.Read(b)
+	if err != nil {
+		t.Fatalf("error reading entry: %v", err)
+	}
+	want := "CONTAINER_UUID=6733c088-a507-4694-aabf-edbe4fc5266f\nMESSAGE=hello\nPRIORITY=6\n"
+	if !bytes.Equal(b[:n], []byte(want)) {
+		t.Errorf("got entry %q, want %q", b[:n], want)
+	}
+}
@@ -0,0 +1,79 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var (
+	ErrLocked = errors.New("directory already locked")
+)
+
+// DirLock represents an advisory lock on a directory, held until the lock is
+// released or the process (and any process which inherited the lock across
+// exec) exits.
+type DirLock struct {
+	dir string
+	f   *os.File
+}
+
+// TryExclusiveLock takes an exclusive lock on dir without blocking. If the
+// lock is held elsewhere, ErrLocked is returned.
+func TryExclusiveLock(dir string) (*DirLock, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("error locking %q: %v", dir, err)
+	}
+	return &DirLock{dir, f}, nil
+}
+
+// ExclusiveLock takes an exclusive lock on dir, waiting for it to be released
+// if it is held elsewhere
+func ExclusiveLock(dir string) (*DirLock, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking %q: %v", dir, err)
+	}
+	return &DirLock{dir, f}, nil
+}
+
+// Unlock releases the lock
+func (l *DirLock) Unlock() error {
+	return l.f.Close()
+}
+
+// InheritOnExec makes the lock survive exec, so that it is held by the
+// executed program
+func (l *DirLock) InheritOnExec() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_FCNTL, l.f.Fd(), syscall.F_SETFD, 0)
+	if errno != 0 {
+		return fmt.Errorf("error clearing close-on-exec on lock of %q: %v", l.dir, errno)
+	}
+	return nil
+}
+
+// IsLocked reports whether dir is currently locked by anyone
+func IsLocked(dir string) (bool, error) {
+	l, err := TryExclusiveLock(dir)
+	switch err {
+	case nil:
+		return false, l.Unlock()
+	case ErrLocked:
+		return true, nil
+	default:
+		return false, err
+	}
+}
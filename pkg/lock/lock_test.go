@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTryExclusiveLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-lock")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if locked, err := IsLocked(dir); err != nil || locked {
+		t.Fatalf("expected unlocked directory, got %v (err: %v)", locked, err)
+	}
+
+	l, err := TryExclusiveLock(dir)
+	if err != nil {
+		t.Fatalf("error locking: %v", err)
+	}
+	if _, err := TryExclusiveLock(dir); err != ErrLocked {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	if locked, err := IsLocked(dir); err != nil || !locked {
+		t.Errorf("expected locked directory, got %v (err: %v)", locked, err)
+	}
+
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("error unlocking: %v", err)
+	}
+	if locked, err := IsLocked(dir); err != nil || locked {
+		t.Errorf("expected unlocked directory, got %v (err: %v)", locked, err)
+	}
+}
+
+func TestExclusiveLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-lock")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := ExclusiveLock(dir)
+	if err != nil {
+		t.Fatalf("error locking: %v", err)
+	}
+
+	locked := make(chan *DirLock)
+	go func() {
+		l, err := ExclusiveLock(dir)
+		if err != nil {
+			t.Errorf("error locking: %v", err)
+		}
+		locked <- l
+	}()
+
+	select {
+	case <-locked:
+		t.Fatalf("lock taken while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("error unlocking: %v", err)
+	}
+	select {
+	case l := <-locked:
+		if l != nil {
+			l.Unlock()
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("lock not taken once released")
+	}
+}
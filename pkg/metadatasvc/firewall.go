@@ -0,0 +1,39 @@
+package metadatasvc
+
+import (
+	"os/exec"
+)
+
+// Firewall keeps the containers registered with the service from getting
+// the metadata of each other, which is looked up by source address
+type Firewall interface {
+	// Isolate makes sure that the packets the container sends through
+	// the host interface ifName have ip as source address
+	Isolate(ip, ifName string) error
+	// Release undoes Isolate, once the container is unregistered
+	Release(ip, ifName string) error
+}
+
+// Ebtables is the Firewall dropping the packets with a spoofed source
+// address coming from the host side interfaces of bridged containers, with
+// ebtables(8)
+type Ebtables struct{}
+
+func (Ebtables) Isolate(ip, ifName string) error {
+	return exec.Command("ebtables", antiSpoofArgs("-I", ip, ifName)...).Run()
+}
+
+func (Ebtables) Release(ip, ifName string) error {
+	return exec.Command("ebtables", antiSpoofArgs("-D", ip, ifName)...).Run()
+}
+
+func antiSpoofArgs(op, ip, ifName string) []string {
+	return []string{"-t", "filter", op, "INPUT", "-i", ifName, "-p", "IPV4", "!", "--ip-source", ip, "-j", "DROP"}
+}
+
+// NoFirewall is the Firewall doing nothing, for when the containers cannot
+// spoof their address anyway (e.g. in tests)
+type NoFirewall struct{}
+
+func (NoFirewall) Isolate(ip, ifName string) error { return nil }
+func (NoFirewall) Release(ip, ifName string) error { return nil }
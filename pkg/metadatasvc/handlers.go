@@ -0,0 +1,323 @@
+package metadatasvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+func queryValue(u *url.URL, key string) string {
+	vals, ok := u.Query()[key]
+	if !ok || len(vals) != 1 {
+		return ""
+	}
+	return vals[0]
+}
+
+// remoteIP returns the address the request comes from
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Service) handleRegisterContainer(w http.ResponseWriter, r *http.Request) {
+	containerIP := queryValue(r.URL, "container_ip")
+	if containerIP == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "container_ip missing")
+		return
+	}
+	containerBrPort := queryValue(r.URL, "container_brport")
+	if containerBrPort == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "container_brport missing")
+		return
+	}
+	// the registration is dropped on restart if the directory is not
+	// locked anymore (cf. registry.load)
+	containerDir := queryValue(r.URL, "container_dir")
+	if !filepath.IsAbs(containerDir) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "container_dir missing or not absolute")
+		return
+	}
+
+	m := &metadata{
+		apps:   make(map[string]*schema.AppManifest),
+		ip:     containerIP,
+		ifName: containerBrPort,
+		dir:    filepath.Clean(containerDir),
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&m.manifest); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "JSON-decoding failed: %v", err)
+		return
+	}
+
+	if err := s.fw.Isolate(containerIP, containerBrPort); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to set anti-spoofing: %v", err)
+		return
+	}
+
+	old, err := s.reg.add(m)
+	switch err {
+	case nil:
+	case errIPInUse:
+		s.fw.Release(containerIP, containerBrPort)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, err)
+		return
+	default:
+		s.fw.Release(containerIP, containerBrPort)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to save registration: %v", err)
+		return
+	}
+	// the rule of the registration replaced goes, even if the new one is
+	// the same: it was set up again above
+	if old != nil {
+		if err := s.fw.Release(old.ip, old.ifName); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "failed to remove former anti-spoofing: %v", err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Service) handleRegisterApp(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	an := mux.Vars(r)["app"]
+
+	app := &schema.AppManifest{}
+	if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "JSON-decoding failed: %v", err)
+		return
+	}
+
+	switch err := s.reg.setApp(*uid, an, app); err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case errNotRegistered:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+	case errUnknownApp:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App (%v) not found in container manifest", an)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to save registration: %v", err)
+	}
+}
+
+func (s *Service) handleUnregisterContainer(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(mux.Vars(r)["uid"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "UUID is missing or mulformed: %v", err)
+		return
+	}
+
+	m, err := s.reg.remove(*uid)
+	switch err {
+	case nil:
+	case errNotRegistered:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "Container with given UUID not found")
+		return
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to remove registration: %v", err)
+		return
+	}
+
+	if err := s.fw.Release(m.ip, m.ifName); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "failed to remove anti-spoofing: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// containerGet returns the handler calling h with the metadata of the
+// container the request comes from
+func (s *Service) containerGet(h func(w http.ResponseWriter, r *http.Request, m *metadata)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		remoteIP := remoteIP(r)
+		m := s.reg.getByIP(remoteIP)
+		if m == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "metadata by remoteIP (%v) not found", remoteIP)
+			return
+		}
+
+		h(w, r, m)
+	}
+}
+
+// appGet returns the handler calling h with the metadata of the container the
+// request comes from, and the image manifest of the app it is about
+func (s *Service) appGet(h func(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest)) func(http.ResponseWriter, *http.Request) {
+	return s.containerGet(func(w http.ResponseWriter, r *http.Request, m *metadata) {
+		appname := mux.Vars(r)["app"]
+
+		if am, ok := m.apps[appname]; ok {
+			h(w, r, m, am)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "App (%v) not found", appname)
+		}
+	})
+}
+
+func handleContainerAnnotations(w http.ResponseWriter, r *http.Request, m *metadata) {
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+
+	for k, _ := range m.manifest.Annotations {
+		fmt.Fprintln(w, k)
+	}
+}
+
+func handleContainerAnnotation(w http.ResponseWriter, r *http.Request, m *metadata) {
+	k, err := types.NewACName(mux.Vars(r)["name"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Container annotation is not a valid AC Label")
+		return
+	}
+
+	v, ok := m.manifest.Annotations[*k]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Container annotation (%v) not found", k)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(v))
+}
+
+func handleContainerManifest(w http.ResponseWriter, r *http.Request, m *metadata) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(m.manifest); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func handleContainerUID(w http.ResponseWriter, r *http.Request, m *metadata) {
+	uid := m.manifest.UUID.String()
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(uid))
+}
+
+// mergeAppAnnotations merges the annotations of the image of an app with the
+// ones given to the app, by name, in the container runtime manifest
+func mergeAppAnnotations(an types.ACName, am *schema.AppManifest, cm *schema.ContainerRuntimeManifest) types.Annotations {
+	merged := make(types.Annotations)
+
+	for k, v := range am.Annotations {
+		merged[k] = v
+	}
+
+	if app := cm.Apps.Get(an); app != nil {
+		for k, v := range app.Annotations {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+func handleAppAnnotations(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest) {
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+
+	for k, _ := range mergeAppAnnotations(types.ACName(mux.Vars(r)["app"]), am, &m.manifest) {
+		fmt.Fprintln(w, k)
+	}
+}
+
+func handleAppAnnotation(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest) {
+	k, err := types.NewACName(mux.Vars(r)["name"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App annotation is not a valid AC Label")
+		return
+	}
+
+	merged := mergeAppAnnotations(types.ACName(mux.Vars(r)["app"]), am, &m.manifest)
+
+	v, ok := merged[*k]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App annotation (%v) not found", k)
+		return
+	}
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(v))
+}
+
+func handleAppImageManifest(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(*am); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// handleAppManifest serves the manifest of the app as it is executed, i.e.
+// with the exec, environment and user/group overrides of the container
+// runtime manifest applied
+func handleAppManifest(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest) {
+	if a := m.manifest.Apps.Get(types.ACName(mux.Vars(r)["app"])); a != nil {
+		am = a.ApplyTo(*am)
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(*am); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func handleAppID(w http.ResponseWriter, r *http.Request, m *metadata, am *schema.AppManifest) {
+	a := m.manifest.Apps.Get(types.ACName(mux.Vars(r)["app"]))
+	if a == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "App (%v) not found in container manifest", mux.Vars(r)["app"])
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(a.ImageID.String()))
+}
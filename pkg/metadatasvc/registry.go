@@ -0,0 +1,123 @@
+package metadatasvc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+var (
+	errNotRegistered = errors.New("container not registered")
+	errIPInUse       = errors.New("IP already registered for another container")
+	errUnknownApp    = errors.New("app not in container manifest")
+)
+
+// metadata is what the service knows about a registered container. It is
+// never modified once in the registry, but replaced, so that it can be read
+// without holding the lock of the registry.
+type metadata struct {
+	manifest schema.ContainerRuntimeManifest
+	apps     map[string]*schema.AppManifest
+	ip       string
+	ifName   string
+	dir      string // the container directory, locked while it runs
+}
+
+// withApp returns a copy of m with the image manifest of the given app
+func (m *metadata) withApp(name string, am *schema.AppManifest) *metadata {
+	nm := *m
+	nm.apps = make(map[string]*schema.AppManifest, len(m.apps)+1)
+	for k, v := range m.apps {
+		nm.apps[k] = v
+	}
+	nm.apps[name] = am
+	return &nm
+}
+
+// registry holds the registered containers, by IP and by UUID, saving them
+// in its state directory (if any) as they change
+type registry struct {
+	mu       sync.RWMutex
+	byIP     map[string]*metadata
+	byUID    map[types.UUID]*metadata
+	stateDir string
+}
+
+func newRegistry(stateDir string) *registry {
+	return &registry{
+		byIP:     make(map[string]*metadata),
+		byUID:    make(map[types.UUID]*metadata),
+		stateDir: stateDir,
+	}
+}
+
+func (r *registry) getByIP(ip string) *metadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byIP[ip]
+}
+
+func (r *registry) getByUID(uid types.UUID) *metadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byUID[uid]
+}
+
+// add registers the container m describes, replacing a previous registration
+// of the same container, which it returns
+func (r *registry) add(m *metadata) (*metadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if o, ok := r.byIP[m.ip]; ok && o.manifest.UUID != m.manifest.UUID {
+		return nil, errIPInUse
+	}
+	if err := r.save(m); err != nil {
+		return nil, err
+	}
+	o := r.byUID[m.manifest.UUID]
+	if o != nil {
+		delete(r.byIP, o.ip)
+	}
+	r.byIP[m.ip] = m
+	r.byUID[m.manifest.UUID] = m
+	return o, nil
+}
+
+// setApp registers the image manifest of an app of a registered container
+func (r *registry) setApp(uid types.UUID, name string, am *schema.AppManifest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.byUID[uid]
+	if !ok {
+		return errNotRegistered
+	}
+	if m.manifest.Apps.Get(types.ACName(name)) == nil {
+		return errUnknownApp
+	}
+	m = m.withApp(name, am)
+	if err := r.save(m); err != nil {
+		return err
+	}
+	r.byIP[m.ip] = m
+	r.byUID[uid] = m
+	return nil
+}
+
+// remove unregisters the container with the given UUID, and returns what was
+// known about it
+func (r *registry) remove(uid types.UUID) (*metadata, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.byUID[uid]
+	if !ok {
+		return nil, errNotRegistered
+	}
+	if err := r.unsave(m); err != nil {
+		return nil, err
+	}
+	delete(r.byUID, uid)
+	delete(r.byIP, m.ip)
+	return m, nil
+}
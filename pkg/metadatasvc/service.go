@@ -0,0 +1,170 @@
+// Package metadatasvc implements the metadata service of the App Container
+// spec: the containers registered with it get their metadata, and have
+// messages signed, over HTTP.
+package metadatasvc
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+// Config configures a Service
+type Config struct {
+	// RegistrationListener is where the containers are registered. It
+	// must not be reachable from the containers (e.g. a Unix socket).
+	RegistrationListener net.Listener
+	// MetadataListener is where the containers get their metadata, which
+	// the containers are told apart by the source address of
+	MetadataListener net.Listener
+	// Firewall keeps the containers from spoofing their address. Defaults
+	// to NoFirewall.
+	Firewall Firewall
+	// StateDir is where the registrations and the HMAC keys are kept, for
+	// them to survive restarts of the service. Without one, they are only
+	// kept in memory.
+	StateDir string
+	// ContainersDir is the directory of the containers run by rkt, which
+	// the registrations reloaded from StateDir that do not record the
+	// directory of their container are checked against
+	ContainersDir string
+	// SignatureTTL is how long the signatures the service issues are
+	// valid. Defaults to DefaultSignatureTTL; it must not be negative.
+	SignatureTTL time.Duration
+	// AcceptLegacySignatures has the signatures of the former scheme
+	// (HMAC-SHA1, without expiry) verified, for the time the containers
+	// using them migrate
+	AcceptLegacySignatures bool
+}
+
+// Service is the metadata service
+type Service struct {
+	cfg Config
+	fw  Firewall
+	reg *registry
+	// the legacy signing scheme has a key of its own, for its signatures
+	// to keep verifying
+	sigKey    [sha256.Size]byte
+	legacyKey [sha1.Size]byte
+	sigTTL    time.Duration
+	now       func() time.Time
+}
+
+// New returns the service serving on the listeners of cfg, with the state
+// it had if it ran before with the same state directory
+func New(cfg Config) (*Service, error) {
+	if cfg.SignatureTTL < 0 {
+		return nil, fmt.Errorf("bad signature TTL %v: must not be negative", cfg.SignatureTTL)
+	}
+	s := &Service{
+		cfg:    cfg,
+		fw:     cfg.Firewall,
+		reg:    newRegistry(cfg.StateDir),
+		sigTTL: cfg.SignatureTTL,
+		now:    time.Now,
+	}
+	if s.fw == nil {
+		s.fw = NoFirewall{}
+	}
+	if s.sigTTL == 0 {
+		s.sigTTL = DefaultSignatureTTL
+	}
+	var sigKeyFile, legacyKeyFile string
+	if cfg.StateDir != "" {
+		sigKeyFile = sigKeyPath(cfg.StateDir)
+		legacyKeyFile = legacyKeyPath(cfg.StateDir)
+	}
+	if err := loadHMACKey(sigKeyFile, s.sigKey[:]); err != nil {
+		return nil, err
+	}
+	if err := loadHMACKey(legacyKeyFile, s.legacyKey[:]); err != nil {
+		return nil, err
+	}
+	if err := s.reg.load(cfg.ContainersDir, s.fw); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Serve serves the registrations and the metadata until either listener
+// fails
+func (s *Service) Serve() error {
+	errc := make(chan error, 2)
+	go func() {
+		errc <- http.Serve(s.cfg.RegistrationListener, s.RegistrationHandler())
+	}()
+	go func() {
+		errc <- http.Serve(s.cfg.MetadataListener, s.MetadataHandler())
+	}()
+	return <-errc
+}
+
+// RegistrationHandler returns the handler of the registration requests:
+//
+//	POST   /containers/?container_ip=IP&container_brport=IFNAME  (body: CRM)
+//	PUT    /containers/UUID/APPNAME                              (body: app manifest)
+//	DELETE /containers/UUID
+func (s *Service) RegistrationHandler() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/containers/", logReq(s.handleRegisterContainer)).Methods("POST")
+	r.HandleFunc("/containers/{uid}", logReq(s.handleUnregisterContainer)).Methods("DELETE")
+	r.HandleFunc("/containers/{uid}/{app:.*}", logReq(s.handleRegisterApp)).Methods("PUT")
+	return r
+}
+
+// MetadataHandler returns the handler of the /acMetadata/v1 requests of the
+// containers
+func (s *Service) MetadataHandler() http.Handler {
+	r := mux.NewRouter()
+	acRtr := r.Headers("Metadata-Flavor", "AppContainer header").
+		PathPrefix("/acMetadata/v1").Subrouter()
+
+	mr := acRtr.Methods("GET").Subrouter()
+
+	mr.HandleFunc("/container/annotations/", logReq(s.containerGet(handleContainerAnnotations)))
+	mr.HandleFunc("/container/annotations/{name}", logReq(s.containerGet(handleContainerAnnotation)))
+	mr.HandleFunc("/container/manifest", logReq(s.containerGet(handleContainerManifest)))
+	mr.HandleFunc("/container/uid", logReq(s.containerGet(handleContainerUID)))
+
+	mr.HandleFunc("/apps/{app:.*}/annotations/", logReq(s.appGet(handleAppAnnotations)))
+	mr.HandleFunc("/apps/{app:.*}/annotations/{name}", logReq(s.appGet(handleAppAnnotation)))
+	mr.HandleFunc("/apps/{app:.*}/image/manifest", logReq(s.appGet(handleAppImageManifest)))
+	mr.HandleFunc("/apps/{app:.*}/image/id", logReq(s.appGet(handleAppID)))
+	// after image/manifest, which it would match otherwise
+	mr.HandleFunc("/apps/{app:.*}/manifest", logReq(s.appGet(handleAppManifest)))
+
+	acRtr.HandleFunc("/container/hmac/sign", logReq(s.handleContainerSign)).Methods("POST")
+	acRtr.HandleFunc("/container/hmac/verify", logReq(s.handleContainerVerify)).Methods("POST")
+	return r
+}
+
+type httpResp struct {
+	writer http.ResponseWriter
+	status int
+}
+
+func (r *httpResp) Header() http.Header {
+	return r.writer.Header()
+}
+
+func (r *httpResp) Write(d []byte) (int, error) {
+	return r.writer.Write(d)
+}
+
+func (r *httpResp) WriteHeader(status int) {
+	r.status = status
+	r.writer.WriteHeader(status)
+}
+
+func logReq(h func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := &httpResp{w, 0}
+		h(resp, r)
+		fmt.Printf("%v %v - %v\n", r.Method, r.RequestURI, resp.status)
+	}
+}
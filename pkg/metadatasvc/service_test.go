@@ -0,0 +1,458 @@
+package metadatasvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+const (
+	testUUID    = "6733c088-a507-4694-aabf-edbe4fc5266f"
+	testCDir    = "/var/lib/rkt/containers/" + testUUID
+	testImageID = "sha256-701c24b2d275f0e291b807a464ae2390bcd8d7c5b4f2d7e47e6fd917cd5e5588"
+
+	testCRM = `{
+	"acVersion": "0.1.0",
+	"acKind": "ContainerRuntimeManifest",
+	"uuid": "` + testUUID + `",
+	"apps": [
+		{
+			"name": "example.com/web",
+			"imageID": "` + testImageID + `",
+			"exec": ["/web", "--verbose"],
+			"annotations": {"role": "frontend"}
+		}
+	],
+	"annotations": {"env": "test"}
+}`
+
+	testAppManifest = `{
+	"acVersion": "0.1.0",
+	"acKind": "AppManifest",
+	"name": "example.com/web",
+	"os": "linux",
+	"arch": "amd64",
+	"exec": ["/web"],
+	"annotations": {"role": "backend", "author": "someone"}
+}`
+)
+
+// testFirewall counts the rules it sets up, by IP and interface, the way
+// ebtables would have them
+type testFirewall struct {
+	mu    sync.Mutex
+	rules map[string]int
+}
+
+func (fw *testFirewall) Isolate(ip, ifName string) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.rules[ip+" "+ifName]++
+	return nil
+}
+
+func (fw *testFirewall) Release(ip, ifName string) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.rules[ip+" "+ifName]--; fw.rules[ip+" "+ifName] <= 0 {
+		delete(fw.rules, ip+" "+ifName)
+	}
+	return nil
+}
+
+// testService is a service registering the container testUUID at the address
+// its metadata server is reached from
+type testService struct {
+	*Service
+	fw  *testFirewall
+	reg *httptest.Server
+	md  *httptest.Server
+}
+
+func newTestService(t *testing.T, stateDir, containersDir string) *testService {
+	fw := &testFirewall{rules: make(map[string]int)}
+	s, err := New(Config{Firewall: fw, StateDir: stateDir, ContainersDir: containersDir})
+	if err != nil {
+		t.Fatalf("error creating service: %v", err)
+	}
+	return &testService{
+		Service: s,
+		fw:      fw,
+		reg:     httptest.NewServer(s.RegistrationHandler()),
+		md:      httptest.NewServer(s.MetadataHandler()),
+	}
+}
+
+func (ts *testService) Close() {
+	ts.reg.Close()
+	ts.md.Close()
+}
+
+func (ts *testService) do(t *testing.T, method, url string, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("Metadata-Flavor", "AppContainer header")
+	if method == "POST" && strings.Contains(url, "/hmac/verify") {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending %s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response: %v", err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func (ts *testService) register(t *testing.T) {
+	ts.registerIn(t, testCDir)
+}
+
+// registerIn registers the container testUUID, running in cdir
+func (ts *testService) registerIn(t *testing.T, cdir string) {
+	q := url.Values{"container_ip": {"127.0.0.1"}, "container_brport": {"veth0"}, "container_dir": {cdir}}
+	if code, body := ts.do(t, "POST", ts.reg.URL+"/containers/?"+q.Encode(), testCRM); code != http.StatusOK {
+		t.Fatalf("error registering container: %d %s", code, body)
+	}
+	if code, body := ts.do(t, "PUT", ts.reg.URL+"/containers/"+testUUID+"/example.com/web", testAppManifest); code != http.StatusOK {
+		t.Fatalf("error registering app: %d %s", code, body)
+	}
+}
+
+func (ts *testService) get(t *testing.T, path string) (int, string) {
+	return ts.do(t, "GET", ts.md.URL+"/acMetadata/v1"+path, "")
+}
+
+func sortedLines(s string) string {
+	l := strings.Split(strings.TrimSpace(s), "\n")
+	sort.Strings(l)
+	return strings.Join(l, "\n")
+}
+
+func TestMetadata(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+
+	if code, _ := ts.get(t, "/container/uid"); code != http.StatusNotFound {
+		t.Errorf("expected 404 before registration, got %d", code)
+	}
+	ts.register(t)
+	if ts.fw.rules["127.0.0.1 veth0"] != 1 {
+		t.Errorf("container not isolated: %v", ts.fw.rules)
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/container/uid", http.StatusOK, testUUID},
+		{"/container/annotations/", http.StatusOK, "env"},
+		{"/container/annotations/env", http.StatusOK, "test"},
+		{"/container/annotations/nope", http.StatusNotFound, ""},
+		{"/apps/example.com/web/annotations/", http.StatusOK, "author\nrole"},
+		// the annotations of the app override the ones of its image
+		{"/apps/example.com/web/annotations/role", http.StatusOK, "frontend"},
+		{"/apps/example.com/web/annotations/author", http.StatusOK, "someone"},
+		{"/apps/example.com/web/annotations/nope", http.StatusNotFound, ""},
+		{"/apps/example.com/web/image/id", http.StatusOK, testImageID},
+		{"/apps/example.com/nope/image/id", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		code, body := ts.get(t, tt.path)
+		if code != tt.code {
+			t.Errorf("%s: expected status %d, got %d (%s)", tt.path, tt.code, code, body)
+			continue
+		}
+		if tt.code == http.StatusOK && sortedLines(body) != tt.body {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.body, body)
+		}
+	}
+
+	// the container manifest comes back as registered
+	code, body := ts.get(t, "/container/manifest")
+	if code != http.StatusOK {
+		t.Fatalf("/container/manifest: expected status 200, got %d", code)
+	}
+	var crm schema.ContainerRuntimeManifest
+	if err := json.Unmarshal([]byte(body), &crm); err != nil {
+		t.Fatalf("/container/manifest: bad manifest: %v", err)
+	}
+	if crm.UUID.String() != testUUID || len(crm.Apps) != 1 {
+		t.Errorf("/container/manifest: unexpected manifest %s", body)
+	}
+
+	// the image manifest is served as is, and the app manifest with the
+	// overrides of the container applied
+	for path, exec := range map[string]string{
+		"/apps/example.com/web/image/manifest": "/web",
+		"/apps/example.com/web/manifest":       "/web --verbose",
+	} {
+		code, body := ts.get(t, path)
+		if code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", path, code)
+			continue
+		}
+		var am schema.AppManifest
+		if err := json.Unmarshal([]byte(body), &am); err != nil {
+			t.Errorf("%s: bad manifest: %v", path, err)
+			continue
+		}
+		if got := strings.Join(am.Exec, " "); got != exec {
+			t.Errorf("%s: expected exec %q, got %q", path, exec, got)
+		}
+	}
+
+	// requests without the metadata flavor header are refused
+	resp, err := http.Get(ts.md.URL + "/acMetadata/v1/container/uid")
+	if err != nil {
+		t.Fatalf("error getting uid: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without Metadata-Flavor, got %d", resp.StatusCode)
+	}
+
+	if code, body := ts.do(t, "DELETE", ts.reg.URL+"/containers/"+testUUID, ""); code != http.StatusOK {
+		t.Fatalf("error unregistering container: %d %s", code, body)
+	}
+	if code, _ := ts.get(t, "/container/uid"); code != http.StatusNotFound {
+		t.Errorf("expected 404 after unregistration, got %d", code)
+	}
+	if len(ts.fw.rules) != 0 {
+		t.Errorf("container not released: %v", ts.fw.rules)
+	}
+	if code, _ := ts.do(t, "DELETE", ts.reg.URL+"/containers/"+testUUID, ""); code != http.StatusNotFound {
+		t.Errorf("expected 404 unregistering twice, got %d", code)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	ts.register(t)
+
+	code, sig := ts.do(t, "POST", ts.md.URL+"/acMetadata/v1/container/hmac/sign", "hello")
+	if code != http.StatusOK {
+		t.Fatalf("error signing: %d %s", code, sig)
+	}
+
+	verify := func(uuid, sig string) int {
+		form := url.Values{"uid": {uuid}, "signature": {sig}}
+		code, _ := ts.do(t, "POST", ts.md.URL+"/acMetadata/v1/container/hmac/verify", form.Encode())
+		return code
+	}
+	if code := verify(testUUID, sig); code != http.StatusOK {
+		t.Errorf("expected signature to verify, got %d", code)
+	}
+	if code := verify("7733c088-a507-4694-aabf-edbe4fc5266f", sig); code != http.StatusForbidden {
+		t.Errorf("expected signature of another container to be refused, got %d", code)
+	}
+	b := []byte(sig)
+	b[len(b)/2] ^= 1
+	if code := verify(testUUID, string(b)); code == http.StatusOK {
+		t.Errorf("expected corrupt signature to be refused")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "state")
+	containersDir := filepath.Join(dir, "containers")
+
+	ts := newTestService(t, stateDir, containersDir)
+	ts.register(t)
+	_, sig := ts.do(t, "POST", ts.md.URL+"/acMetadata/v1/container/hmac/sign", "hello")
+	ts.Close()
+
+	// the container has exited: its registration is dropped on restart
+	ts = newTestService(t, stateDir, containersDir)
+	if code, _ := ts.get(t, "/container/uid"); code != http.StatusNotFound {
+		t.Errorf("expected registration of exited container to be dropped, got %d", code)
+	}
+	// but the key survives
+	form := url.Values{"uid": {testUUID}, "signature": {sig}}
+	if code, _ := ts.do(t, "POST", ts.md.URL+"/acMetadata/v1/container/hmac/verify", form.Encode()); code != http.StatusOK {
+		t.Errorf("expected signature to verify after restart, got %d", code)
+	}
+	ts.Close()
+
+	// a running container keeps its registration, wherever its directory
+	cdir := filepath.Join(dir, "elsewhere", testUUID)
+	if err := os.MkdirAll(cdir, 0755); err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+	ts = newTestService(t, stateDir, containersDir)
+	ts.registerIn(t, cdir)
+	ts.Close()
+	l, err := lock.TryExclusiveLock(cdir)
+	if err != nil {
+		t.Fatalf("error locking container dir: %v", err)
+	}
+	defer l.Unlock()
+	ts = newTestService(t, stateDir, containersDir)
+	defer ts.Close()
+	if code, body := ts.get(t, "/apps/example.com/web/image/id"); code != http.StatusOK || body != testImageID {
+		t.Errorf("expected registration of running container to be kept, got %d %s", code, body)
+	}
+}
+
+func TestPersistenceWithoutDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "state")
+	containersDir := filepath.Join(dir, "containers")
+
+	// registrations saved before container directories were recorded
+	// have their container looked up in containersDir
+	for _, uuid := range []string{testUUID, "7733c088-a507-4694-aabf-edbe4fc5266f"} {
+		crm := strings.Replace(testCRM, testUUID, uuid, 1)
+		reg := `{"manifest": ` + crm + `, "ip": "10.0.0.` + uuid[:1] + `", "brPort": "veth` + uuid[:1] + `"}`
+		if err := writeFileAtomic(filepath.Join(stateDir, "containers", uuid+".json"), []byte(reg), 0600); err != nil {
+			t.Fatalf("error writing registration: %v", err)
+		}
+	}
+	cdir := filepath.Join(containersDir, testUUID)
+	if err := os.MkdirAll(cdir, 0755); err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+	l, err := lock.TryExclusiveLock(cdir)
+	if err != nil {
+		t.Fatalf("error locking container dir: %v", err)
+	}
+	defer l.Unlock()
+
+	ts := newTestService(t, stateDir, containersDir)
+	defer ts.Close()
+	if m := ts.Service.reg.getByIP("10.0.0.6"); m == nil || m.dir != cdir {
+		t.Errorf("expected registration of running container to be kept in %s, got %+v", cdir, m)
+	}
+	if m := ts.Service.reg.getByIP("10.0.0.7"); m != nil {
+		t.Errorf("expected registration of exited container to be dropped")
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "containers", "7733c088-a507-4694-aabf-edbe4fc5266f.json")); !os.IsNotExist(err) {
+		t.Errorf("expected saved registration of exited container to be removed")
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+
+	for _, q := range []string{
+		"",
+		"container_ip=127.0.0.1",
+		"container_brport=veth0",
+		"container_ip=127.0.0.1&container_brport=veth0",
+		"container_ip=127.0.0.1&container_brport=veth0&container_dir=containers/" + testUUID,
+	} {
+		if code, _ := ts.do(t, "POST", ts.reg.URL+"/containers/?"+q, testCRM); code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", q, code)
+		}
+	}
+	q := "container_ip=127.0.0.1&container_brport=veth0&container_dir=" + testCDir
+	if code, _ := ts.do(t, "POST", ts.reg.URL+"/containers/?"+q, "{}"); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for bad manifest, got %d", code)
+	}
+	if code, _ := ts.do(t, "PUT", ts.reg.URL+"/containers/"+testUUID+"/example.com/web", testAppManifest); code != http.StatusNotFound {
+		t.Errorf("expected 404 registering app of unknown container, got %d", code)
+	}
+
+	ts.register(t)
+	other := bytes.Replace([]byte(testCRM), []byte("6733c088"), []byte("7733c088"), 1)
+	if code, _ := ts.do(t, "POST", ts.reg.URL+"/containers/?"+q, string(other)); code != http.StatusConflict {
+		t.Errorf("expected 409 registering another container at the same IP, got %d", code)
+	}
+}
+
+func TestRegistrationFirewall(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	register := func(crm, ip, ifName string) int {
+		q := url.Values{"container_ip": {ip}, "container_brport": {ifName}, "container_dir": {testCDir}}
+		code, _ := ts.do(t, "POST", ts.reg.URL+"/containers/?"+q.Encode(), crm)
+		return code
+	}
+	other := strings.Replace(testCRM, testUUID, "7733c088-a507-4694-aabf-edbe4fc5266f", 1)
+
+	tests := []struct {
+		crm    string
+		ip     string
+		ifName string
+		code   int
+		rules  string
+	}{
+		{testCRM, "127.0.0.1", "veth0", http.StatusOK, "127.0.0.1 veth0"},
+		// registering again replaces the rule
+		{testCRM, "127.0.0.1", "veth0", http.StatusOK, "127.0.0.1 veth0"},
+		{testCRM, "127.0.0.2", "veth1", http.StatusOK, "127.0.0.2 veth1"},
+		// the former address of the container is free
+		{other, "127.0.0.1", "veth2", http.StatusOK, "127.0.0.1 veth2,127.0.0.2 veth1"},
+		// conflicting registrations leave no rule behind
+		{other, "127.0.0.2", "veth2", http.StatusConflict, "127.0.0.1 veth2,127.0.0.2 veth1"},
+	}
+	for i, tt := range tests {
+		if code := register(tt.crm, tt.ip, tt.ifName); code != tt.code {
+			t.Errorf("#%d: got %d, want %d", i, code, tt.code)
+		}
+		var rules []string
+		for r, n := range ts.fw.rules {
+			for j := 0; j < n; j++ {
+				rules = append(rules, r)
+			}
+		}
+		sort.Strings(rules)
+		if got := strings.Join(rules, ","); got != tt.rules {
+			t.Errorf("#%d: got rules %q, want %q", i, got, tt.rules)
+		}
+	}
+}
+
+func TestUnknownApp(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	ts.register(t)
+
+	if code, _ := ts.do(t, "PUT", ts.reg.URL+"/containers/"+testUUID+"/example.com/db", testAppManifest); code != http.StatusNotFound {
+		t.Errorf("expected 404 registering app not in container manifest, got %d", code)
+	}
+	if code, _ := ts.get(t, "/apps/example.com/db/image/id"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for unregistered app, got %d", code)
+	}
+
+	// registrations saved before apps were checked may have such apps
+	m := ts.Service.reg.getByIP("127.0.0.1")
+	if _, err := ts.Service.reg.add(m.withApp("example.com/db", m.apps["example.com/web"])); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code, _ := ts.get(t, "/apps/example.com/db/image/id"); code != http.StatusNotFound {
+		t.Errorf("expected 404 for app not in container manifest, got %d", code)
+	}
+	if code, body := ts.get(t, "/apps/example.com/web/image/id"); code != http.StatusOK || body != testImageID {
+		t.Errorf("got %d %s, want image ID of app", code, body)
+	}
+}
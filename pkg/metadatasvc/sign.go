@@ -0,0 +1,251 @@
+package metadatasvc
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// Containers have the service sign messages (POST /container/hmac/sign, the
+// body being the message), for others to check with the service that a
+// message comes from a given container (POST /container/hmac/verify, with
+// the uid and signature form fields).
+//
+// A signature is the base64 encoding of:
+//
+//	version   1 byte, sigVersion
+//	issued    8 bytes, Unix time the signature was issued at (big endian)
+//	expires   8 bytes, Unix time the signature expires at (big endian)
+//	audLen    2 bytes, length of the audience (big endian)
+//	audience  audLen bytes, who the signature is meant for, if anyone
+//	digest    SHA-256 of the message
+//	mac       HMAC-SHA256 of all the above but the mac, with the UUID of the
+//	          container inserted before the digest
+//
+// The audience is given to sign as the audience query parameter; a signature
+// with an audience only verifies for a verifier giving the same audience
+// form field.
+//
+// Legacy signatures, the base64 encoding of the SHA-1 digest of the message
+// followed by the HMAC-SHA1 of the UUID of the container and the digest, do
+// not expire and are only verified if the service is configured to.
+
+const (
+	sigVersion = 1
+
+	// DefaultSignatureTTL is how long signatures are valid by default
+	DefaultSignatureTTL = time.Hour
+
+	// clockSkew is how far in the future a signature may have been issued,
+	// should the clock go back
+	clockSkew = time.Minute
+
+	sigHeaderLen = 1 + 8 + 8 + 2
+	legacySigLen = sha1.Size * 2
+)
+
+// signature is a decoded signature
+type signature struct {
+	version  byte
+	issued   time.Time
+	expires  time.Time
+	audience string
+	digest   []byte
+	mac      []byte
+}
+
+// The reasons a signature does not verify, reported to the verifier
+var (
+	errSigMalformed   = errors.New("malformed signature")
+	errSigVersion     = errors.New("unsupported signature version")
+	errSigLegacy      = errors.New("legacy signatures are not accepted")
+	errSigMismatch    = errors.New("signature does not match")
+	errSigExpired     = errors.New("signature expired")
+	errSigNotYetValid = errors.New("signature issued in the future")
+	errSigAudience    = errors.New("signature is for another audience")
+)
+
+// header returns the part of the signature preceding the digest
+func (sig *signature) header() []byte {
+	var b bytes.Buffer
+	b.WriteByte(sig.version)
+	binary.Write(&b, binary.BigEndian, sig.issued.Unix())
+	binary.Write(&b, binary.BigEndian, sig.expires.Unix())
+	binary.Write(&b, binary.BigEndian, uint16(len(sig.audience)))
+	b.WriteString(sig.audience)
+	return b.Bytes()
+}
+
+// computeMAC returns the MAC of the signature for the container uid
+func (sig *signature) computeMAC(key []byte, uid types.UUID) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(sig.header())
+	h.Write(uid[:])
+	h.Write(sig.digest)
+	return h.Sum(nil)
+}
+
+func (sig *signature) encode() string {
+	b := sig.header()
+	b = append(b, sig.digest...)
+	b = append(b, sig.mac...)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func decodeSignature(b []byte) (*signature, error) {
+	if len(b) < sigHeaderLen {
+		return nil, errSigMalformed
+	}
+	if b[0] != sigVersion {
+		return nil, errSigVersion
+	}
+	sig := &signature{version: b[0]}
+	sig.issued = time.Unix(int64(binary.BigEndian.Uint64(b[1:9])), 0)
+	sig.expires = time.Unix(int64(binary.BigEndian.Uint64(b[9:17])), 0)
+	audLen := int(binary.BigEndian.Uint16(b[17:19]))
+	b = b[sigHeaderLen:]
+	if len(b) != audLen+sha256.Size*2 {
+		return nil, errSigMalformed
+	}
+	sig.audience = string(b[:audLen])
+	sig.digest = b[audLen : audLen+sha256.Size]
+	sig.mac = b[audLen+sha256.Size:]
+	return sig, nil
+}
+
+// sign returns the signature of the message with the given SHA-256 digest,
+// by the container uid, for the given audience
+func (s *Service) sign(uid types.UUID, digest []byte, audience string) *signature {
+	now := s.now()
+	sig := &signature{
+		version:  sigVersion,
+		issued:   now,
+		expires:  now.Add(s.sigTTL),
+		audience: audience,
+		digest:   digest,
+	}
+	sig.mac = sig.computeMAC(s.sigKey[:], uid)
+	return sig
+}
+
+// verify checks that the encoded signature b was issued by the service for
+// the container uid, is still valid, and is meant for the given audience
+func (s *Service) verify(uid types.UUID, b []byte, audience string) error {
+	if len(b) == legacySigLen {
+		if !s.cfg.AcceptLegacySignatures {
+			return errSigLegacy
+		}
+		return s.verifyLegacy(uid, b)
+	}
+
+	sig, err := decodeSignature(b)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sig.mac, sig.computeMAC(s.sigKey[:], uid)) {
+		return errSigMismatch
+	}
+	now := s.now()
+	if sig.issued.After(now.Add(clockSkew)) {
+		return errSigNotYetValid
+	}
+	if !now.Before(sig.expires) {
+		return errSigExpired
+	}
+	if sig.audience != "" && sig.audience != audience {
+		return errSigAudience
+	}
+	return nil
+}
+
+// verifyLegacy checks a signature of the SHA-1 scheme
+func (s *Service) verifyLegacy(uid types.UUID, b []byte) error {
+	digest := b[:sha1.Size]
+	sum := b[sha1.Size:]
+
+	h := hmac.New(sha1.New, s.legacyKey[:])
+	h.Write(uid[:])
+	h.Write(digest)
+
+	if !hmac.Equal(sum, h.Sum(nil)) {
+		return errSigMismatch
+	}
+	return nil
+}
+
+func digest(r io.Reader) ([]byte, error) {
+	digest := sha256.New()
+	if _, err := io.Copy(digest, r); err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+func (s *Service) handleContainerSign(w http.ResponseWriter, r *http.Request) {
+	remoteIP := remoteIP(r)
+	m := s.reg.getByIP(remoteIP)
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Metadata by remoteIP (%v) not found", remoteIP)
+		return
+	}
+
+	audience := queryValue(r.URL, "audience")
+	if len(audience) > 0xffff {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "audience too long")
+		return
+	}
+
+	// compute message digest
+	d, err := digest(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Digest computation failed: %v", err)
+		return
+	}
+
+	sig := s.sign(m.manifest.UUID, d, audience)
+
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(sig.encode()))
+}
+
+func (s *Service) handleContainerVerify(w http.ResponseWriter, r *http.Request) {
+	uid, err := types.NewUUID(r.FormValue("uid"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "uid field missing or malformed: %v", err)
+		return
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(r.FormValue("signature"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "signature field missing or corrupt: %v", err)
+		return
+	}
+
+	// the reason a signature does not verify is told to the verifier
+	switch err := s.verify(*uid, sig, r.FormValue("audience")); err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case errSigMalformed:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+	default:
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, err)
+	}
+}
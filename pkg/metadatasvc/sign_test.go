@@ -0,0 +1,195 @@
+package metadatasvc
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// verifyReason returns the status and failure reason of the verification of
+// sig for the container uuid and the given audience
+func (ts *testService) verifyReason(t *testing.T, uuid, sig, audience string) (int, string) {
+	form := url.Values{"uid": {uuid}, "signature": {sig}}
+	if audience != "" {
+		form.Set("audience", audience)
+	}
+	return ts.do(t, "POST", ts.md.URL+"/acMetadata/v1/container/hmac/verify", form.Encode())
+}
+
+func (ts *testService) signFor(t *testing.T, audience string) string {
+	u := ts.md.URL + "/acMetadata/v1/container/hmac/sign"
+	if audience != "" {
+		u += "?" + url.Values{"audience": {audience}}.Encode()
+	}
+	code, sig := ts.do(t, "POST", u, "hello")
+	if code != http.StatusOK {
+		t.Fatalf("error signing: %d %s", code, sig)
+	}
+	return sig
+}
+
+func TestSignatureExpiry(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	ts.register(t)
+
+	now := time.Unix(1420070400, 0)
+	ts.now = func() time.Time { return now }
+	sig := ts.signFor(t, "")
+
+	for _, tt := range []struct {
+		at     time.Duration
+		code   int
+		reason error
+	}{
+		{0, http.StatusOK, nil},
+		{DefaultSignatureTTL - time.Second, http.StatusOK, nil},
+		{DefaultSignatureTTL, http.StatusForbidden, errSigExpired},
+		{-2 * clockSkew, http.StatusForbidden, errSigNotYetValid},
+	} {
+		now = time.Unix(1420070400, 0).Add(tt.at)
+		code, body := ts.verifyReason(t, testUUID, sig, "")
+		if code != tt.code {
+			t.Errorf("at %v: expected status %d, got %d (%s)", tt.at, tt.code, code, body)
+		}
+		if tt.reason != nil && body != tt.reason.Error() {
+			t.Errorf("at %v: expected reason %q, got %q", tt.at, tt.reason, body)
+		}
+	}
+}
+
+func TestBadSignatureTTL(t *testing.T) {
+	if _, err := New(Config{SignatureTTL: -time.Second}); err == nil {
+		t.Errorf("expected negative signature TTL to be refused")
+	}
+}
+
+func TestSigningKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-metadatasvc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "state")
+
+	ts := newTestService(t, stateDir, "")
+	ts.Close()
+	if hmac.Equal(ts.sigKey[:len(ts.legacyKey)], ts.legacyKey[:]) {
+		t.Errorf("expected signatures and legacy signatures to have keys of their own")
+	}
+	for _, tt := range []struct {
+		path string
+		key  []byte
+	}{
+		{sigKeyPath(stateDir), ts.sigKey[:]},
+		{legacyKeyPath(stateDir), ts.legacyKey[:]},
+	} {
+		b, err := ioutil.ReadFile(tt.path)
+		if err != nil {
+			t.Fatalf("error reading key: %v", err)
+		}
+		if !hmac.Equal(b, tt.key) {
+			t.Errorf("%s: expected key to be saved", tt.path)
+		}
+	}
+
+	// both keys survive restarts
+	rs := newTestService(t, stateDir, "")
+	defer rs.Close()
+	if rs.sigKey != ts.sigKey || rs.legacyKey != ts.legacyKey {
+		t.Errorf("expected keys to be reloaded on restart")
+	}
+}
+
+func TestSignatureAudience(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	ts.register(t)
+
+	sig := ts.signFor(t, "example.com/db")
+	if code, body := ts.verifyReason(t, testUUID, sig, "example.com/db"); code != http.StatusOK {
+		t.Errorf("expected signature to verify for its audience, got %d (%s)", code, body)
+	}
+	for _, aud := range []string{"", "example.com/web"} {
+		code, body := ts.verifyReason(t, testUUID, sig, aud)
+		if code != http.StatusForbidden || body != errSigAudience.Error() {
+			t.Errorf("audience %q: expected %q, got %d (%s)", aud, errSigAudience, code, body)
+		}
+	}
+
+	// a signature without audience verifies for anyone
+	sig = ts.signFor(t, "")
+	if code, body := ts.verifyReason(t, testUUID, sig, "example.com/web"); code != http.StatusOK {
+		t.Errorf("expected signature without audience to verify, got %d (%s)", code, body)
+	}
+}
+
+func TestSignatureReasons(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+	ts.register(t)
+	sig := ts.signFor(t, "")
+
+	b, _ := base64.StdEncoding.DecodeString(sig)
+	enc := base64.StdEncoding.EncodeToString
+
+	tampered := append([]byte(nil), b...)
+	tampered[1] ^= 1 // issue time
+	version := append([]byte(nil), b...)
+	version[0] = 2
+
+	for _, tt := range []struct {
+		desc   string
+		uuid   string
+		sig    string
+		code   int
+		reason error
+	}{
+		{"other container", "7733c088-a507-4694-aabf-edbe4fc5266f", sig, http.StatusForbidden, errSigMismatch},
+		{"tampered", testUUID, enc(tampered), http.StatusForbidden, errSigMismatch},
+		{"version", testUUID, enc(version), http.StatusForbidden, errSigVersion},
+		{"truncated", testUUID, enc(b[:len(b)-1]), http.StatusBadRequest, errSigMalformed},
+		{"short", testUUID, enc(b[:5]), http.StatusBadRequest, errSigMalformed},
+	} {
+		code, body := ts.verifyReason(t, tt.uuid, tt.sig, "")
+		if code != tt.code || body != tt.reason.Error() {
+			t.Errorf("%s: expected %d (%s), got %d (%s)", tt.desc, tt.code, tt.reason, code, body)
+		}
+	}
+}
+
+func TestLegacySignatures(t *testing.T) {
+	ts := newTestService(t, "", "")
+	defer ts.Close()
+
+	// a signature of the former scheme
+	uid, _ := types.NewUUID(testUUID)
+	d := sha1.Sum([]byte("hello"))
+	h := hmac.New(sha1.New, ts.legacyKey[:])
+	h.Write(uid[:])
+	h.Write(d[:])
+	sig := base64.StdEncoding.EncodeToString(append(d[:], h.Sum(nil)...))
+
+	code, body := ts.verifyReason(t, testUUID, sig, "")
+	if code != http.StatusForbidden || body != errSigLegacy.Error() {
+		t.Errorf("expected legacy signature to be refused, got %d (%s)", code, body)
+	}
+
+	ts.cfg.AcceptLegacySignatures = true
+	if code, body := ts.verifyReason(t, testUUID, sig, ""); code != http.StatusOK {
+		t.Errorf("expected legacy signature to verify, got %d (%s)", code, body)
+	}
+	code, body = ts.verifyReason(t, "7733c088-a507-4694-aabf-edbe4fc5266f", sig, "")
+	if code != http.StatusForbidden || body != errSigMismatch.Error() {
+		t.Errorf("expected legacy signature of another container to be refused, got %d (%s)", code, body)
+	}
+}
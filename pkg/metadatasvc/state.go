@@ -0,0 +1,197 @@
+package metadatasvc
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+// The state of the service is kept under its state directory, for it to be
+// restarted (e.g. upgraded) without forgetting the containers registered with
+// it nor invalidating the signatures it issued:
+//
+//	sig-key                the key signatures are computed with
+//	hmac-key               the key of the former HMAC-SHA1 signatures
+//	containers/UUID.json   the registration of the container UUID
+
+func sigKeyPath(stateDir string) string {
+	return filepath.Join(stateDir, "sig-key")
+}
+
+func legacyKeyPath(stateDir string) string {
+	return filepath.Join(stateDir, "hmac-key")
+}
+
+func registrationsDir(stateDir string) string {
+	return filepath.Join(stateDir, "containers")
+}
+
+func registrationPath(stateDir string, uid types.UUID) string {
+	return filepath.Join(registrationsDir(stateDir), uid.String()+".json")
+}
+
+// registration is how the metadata of a container is saved
+type registration struct {
+	Manifest schema.ContainerRuntimeManifest `json:"manifest"`
+	Apps     map[string]*schema.AppManifest  `json:"apps"`
+	IP       string                          `json:"ip"`
+	IfName   string                          `json:"brPort"`
+	Dir      string                          `json:"containerDir"`
+}
+
+// loadHMACKey loads an HMAC key from the file at path, generating it the
+// first time the service runs. Without a path, a new key is generated every
+// time.
+func loadHMACKey(path string, key []byte) error {
+	if path == "" {
+		return genHMACKey(key)
+	}
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := genHMACKey(key); err != nil {
+			return err
+		}
+		return writeFileAtomic(path, key, 0600)
+	case err != nil:
+		return fmt.Errorf("error reading HMAC key: %v", err)
+	case len(b) != len(key):
+		return fmt.Errorf("bad HMAC key in %s: %d bytes instead of %d", path, len(b), len(key))
+	}
+	copy(key, b)
+	return nil
+}
+
+func genHMACKey(key []byte) error {
+	if n, err := rand.Reader.Read(key); err != nil || n != len(key) {
+		return fmt.Errorf("failed to generate HMAC Key")
+	}
+	return nil
+}
+
+// save saves the registration of the container m describes, if the registry
+// has a state directory
+func (r *registry) save(m *metadata) error {
+	if r.stateDir == "" {
+		return nil
+	}
+	b, err := json.Marshal(registration{
+		Manifest: m.manifest,
+		Apps:     m.apps,
+		IP:       m.ip,
+		IfName:   m.ifName,
+		Dir:      m.dir,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(registrationPath(r.stateDir, m.manifest.UUID), b, 0600)
+}
+
+// unsave removes the saved registration of the container m describes
+func (r *registry) unsave(m *metadata) error {
+	if r.stateDir == "" {
+		return nil
+	}
+	err := os.Remove(registrationPath(r.stateDir, m.manifest.UUID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// load restores the saved registrations of the containers that are still
+// running, i.e. whose recorded directory is locked, and removes the ones of
+// the containers that have exited while the service was not running,
+// releasing them from the firewall. Registrations saved before the container
+// directories were recorded are checked against the directories of the
+// containers under containersDir.
+func (r *registry) load(containersDir string, fw Firewall) error {
+	if r.stateDir == "" {
+		return nil
+	}
+	fis, err := ioutil.ReadDir(registrationsDir(r.stateDir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading registrations: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		fn := filepath.Join(registrationsDir(r.stateDir), fi.Name())
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("error reading registration: %v", err)
+		}
+		var reg registration
+		if err := json.Unmarshal(b, &reg); err != nil {
+			log.Printf("Removing corrupt registration %s: %v", fn, err)
+			os.Remove(fn)
+			continue
+		}
+		m := &metadata{
+			manifest: reg.Manifest,
+			apps:     reg.Apps,
+			ip:       reg.IP,
+			ifName:   reg.IfName,
+			dir:      reg.Dir,
+		}
+		if m.apps == nil {
+			m.apps = make(map[string]*schema.AppManifest)
+		}
+		if m.dir == "" && containersDir != "" {
+			m.dir = filepath.Join(containersDir, m.manifest.UUID.String())
+		}
+
+		running := false
+		if m.dir != "" {
+			if running, err = lock.IsLocked(m.dir); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error checking container %v: %v", m.manifest.UUID, err)
+			}
+		}
+		if !running {
+			log.Printf("Removing registration of exited container %v", m.manifest.UUID)
+			fw.Release(m.ip, m.ifName)
+			if err := r.unsave(m); err != nil {
+				return fmt.Errorf("error removing registration: %v", err)
+			}
+			continue
+		}
+
+		r.byIP[m.ip] = m
+		r.byUID[m.manifest.UUID] = m
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to the file fn, which is replaced at once so
+// that a crash never leaves it half written
+func writeFileAtomic(fn string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+		return err
+	}
+	tmp := fn + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
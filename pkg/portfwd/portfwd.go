@@ -0,0 +1,39 @@
+package portfwd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// Request asks for the app port with the given name to be published on the
+// host as HostPort. It is given to `rkt run --port`, and passed on to stage1
+// in the same NAME:HOSTPORT form.
+type Request struct {
+	Name     types.ACName
+	HostPort uint
+}
+
+// Parse parses a request in the "NAME:HOSTPORT" format
+func Parse(s string) (*Request, error) {
+	elems := strings.Split(s, ":")
+	if len(elems) != 2 {
+		return nil, errors.New("port must be of form name:hostport")
+	}
+	name, err := types.NewACName(elems[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad port name %q: %v", elems[0], err)
+	}
+	port, err := strconv.ParseUint(elems[1], 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("bad host port %q", elems[1])
+	}
+	return &Request{Name: *name, HostPort: uint(port)}, nil
+}
+
+func (r Request) String() string {
+	return fmt.Sprintf("%s:%d", r.Name, r.HostPort)
+}
@@ -0,0 +1,39 @@
+package portfwd
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in string
+		r  *Request
+	}{
+		{"http:8080", &Request{"http", 8080}},
+		{"dns-udp:53", &Request{"dns-udp", 53}},
+		{"http", nil},
+		{"http:8080:80", nil},
+		{"Bad_Name:80", nil},
+		{"http:0", nil},
+		{"http:65536", nil},
+		{"http:-1", nil},
+		{":80", nil},
+	}
+	for i, tt := range tests {
+		r, err := Parse(tt.in)
+		if tt.r == nil {
+			if err == nil {
+				t.Errorf("#%d: expected error parsing %q, got %v", i, tt.in, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error parsing %q: %v", i, tt.in, err)
+			continue
+		}
+		if *r != *tt.r {
+			t.Errorf("#%d: got %v, want %v", i, r, tt.r)
+		}
+		if r.String() != tt.in {
+			t.Errorf("#%d: %v formats as %q", i, r, r.String())
+		}
+	}
+}
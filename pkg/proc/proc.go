@@ -3,6 +3,7 @@ package proc
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -194,3 +195,40 @@ func mmapsWithPrefix(r io.Reader, pre string) ([]string, error) {
 	}
 	return paths, nil
 }
+
+// ChildPid returns the PID of the child of the process with the given PID,
+// which is expected to have exactly one (e.g. the init of a container, for
+// the process running it)
+func ChildPid(ppid int) (int, error) {
+	ps, err := ioutil.ReadDir(procfs)
+	if err != nil {
+		return 0, err
+	}
+	child := 0
+	for _, p := range ps {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procfs, p.Name(), "stat"))
+		if err != nil {
+			// gone already
+			continue
+		}
+		// the name of the command (in parentheses) may contain spaces:
+		// the state then the parent PID follow its closing parenthesis
+		s := string(b)
+		fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+		if len(fields) < 2 || fields[1] != strconv.Itoa(ppid) {
+			continue
+		}
+		if child != 0 {
+			return 0, fmt.Errorf("process %d has several children", ppid)
+		}
+		child = pid
+	}
+	if child == 0 {
+		return 0, fmt.Errorf("process %d has no children", ppid)
+	}
+	return child, nil
+}
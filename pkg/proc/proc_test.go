@@ -0,0 +1,30 @@
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestChildPid(t *testing.T) {
+	if _, err := ChildPid(os.Getpid()); err == nil {
+		t.Fatalf("got no error for a process without children")
+	}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start child: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	pid, err := ChildPid(os.Getpid())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pid != cmd.Process.Pid {
+		t.Errorf("got pid %d, want %d", pid, cmd.Process.Pid)
+	}
+}
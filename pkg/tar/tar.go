@@ -8,12 +8,21 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+
+	"github.com/coreos/rocket/pkg/uid"
 )
 
 type insecureLinkError error
 
 // ExtractTar extracts a tarball (from a tar.Reader) into the given directory
 func ExtractTar(tr *tar.Reader, dir string) error {
+	return ExtractTarShifted(tr, dir, nil)
+}
+
+// ExtractTarShifted extracts a tarball like ExtractTar. If r is not nil, the
+// extracted files are then owned by the owners recorded in the tarball,
+// shifted into r, i.e. as seen from a user namespace mapped to r.
+func ExtractTarShifted(tr *tar.Reader, dir string, r *uid.Range) error {
 	um := syscall.Umask(0)
 	defer syscall.Umask(um)
 	for {
@@ -74,12 +83,39 @@ func ExtractTar(tr *tar.Reader, dir string) error {
 			default:
 				return fmt.Errorf("unsupported type: %v", typ)
 			}
+			// hard links share the owner of their target
+			if r != nil && typ != tar.TypeLink {
+				if err := shiftOwner(p, hdr, r); err != nil {
+					return err
+				}
+			}
 		default:
 			return fmt.Errorf("error extracting tarball: %v", err)
 		}
 	}
 }
 
+// shiftOwner sets the owner of the extracted file p to the one of its tar
+// header, shifted into r
+func shiftOwner(p string, hdr *tar.Header, r *uid.Range) error {
+	u, err := r.Shift(hdr.Uid)
+	if err != nil {
+		return fmt.Errorf("error shifting owner of %q: %v", hdr.Name, err)
+	}
+	g, err := r.Shift(hdr.Gid)
+	if err != nil {
+		return fmt.Errorf("error shifting group of %q: %v", hdr.Name, err)
+	}
+	if err := os.Lchown(p, u, g); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// chown clears the setuid and setgid bits
+	return os.Chmod(p, hdr.FileInfo().Mode())
+}
+
 // makedev mimics glib's gnu_dev_makedev
 func makedev(major, minor int) int {
 	return (minor & 0xff) | (major & 0xfff << 8) | int((uint64(minor & ^0xff) << 12)) | int(uint64(major & ^0xfff)<<32)
@@ -5,7 +5,11 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
+
+	"github.com/coreos/rocket/pkg/uid"
 )
 
 type testTarEntry struct {
@@ -91,3 +95,83 @@ func TestExtractTarInsecureSymlink(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractTarShifted(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing file owners needs root privileges")
+	}
+	entries := []*testTarEntry{
+		{
+			header: &tar.Header{
+				Name:     "dir",
+				Typeflag: tar.TypeDir,
+				Mode:     0755,
+				Uid:      0,
+				Gid:      0,
+			},
+		},
+		{
+			contents: "hello",
+			header: &tar.Header{
+				Name: "dir/hello.txt",
+				Size: 5,
+				Mode: 04755,
+				Uid:  1000,
+				Gid:  100,
+			},
+		},
+		{
+			header: &tar.Header{
+				Name:     "dir/link.txt",
+				Linkname: "hello.txt",
+				Typeflag: tar.TypeSymlink,
+				Uid:      1,
+				Gid:      1,
+			},
+		},
+	}
+	testTarPath, err := newTestTar(entries)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(testTarPath)
+	containerTar, err := os.Open(testTarPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer containerTar.Close()
+	tmpdir, err := ioutil.TempDir("", "rocket-temp-dir")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	r := &uid.Range{Base: 100000, Count: 65536}
+	if err := ExtractTarShifted(tar.NewReader(containerTar), tmpdir, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		uid, gid uint32
+		mode     os.FileMode
+	}{
+		{"dir", 100000, 100000, os.ModeDir | 0755},
+		{"dir/hello.txt", 101000, 100100, os.ModeSetuid | 0755},
+		{"dir/link.txt", 100001, 100001, 0},
+	}
+	for _, tt := range tests {
+		fi, err := os.Lstat(filepath.Join(tmpdir, tt.name))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != tt.uid || st.Gid != tt.gid {
+			t.Errorf("%s: got owner %d:%d, want %d:%d", tt.name, st.Uid, st.Gid, tt.uid, tt.gid)
+		}
+		if tt.mode != 0 && fi.Mode() != tt.mode {
+			t.Errorf("%s: got mode %v, want %v", tt.name, fi.Mode(), tt.mode)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package uid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCount is the number of uids in a range when not given explicitly:
+// enough for all the 16-bit uids
+const DefaultCount = 65536
+
+// Range is a range of host uids (and gids) the uids of a user namespace are
+// mapped to: uid N in the namespace is uid Base+N on the host.
+type Range struct {
+	Base  uint32
+	Count uint32
+}
+
+// ParseRange parses a range in the "BASE[:COUNT]" format
+func ParseRange(s string) (*Range, error) {
+	elems := strings.Split(s, ":")
+	if len(elems) > 2 {
+		return nil, fmt.Errorf("uid range must be of form base[:count]")
+	}
+	base, err := strconv.ParseUint(elems[0], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("bad uid range base %q", elems[0])
+	}
+	count := uint64(DefaultCount)
+	if len(elems) == 2 {
+		count, err = strconv.ParseUint(elems[1], 10, 32)
+		if err != nil || count == 0 {
+			return nil, fmt.Errorf("bad uid range count %q", elems[1])
+		}
+	}
+	if base+count-1 > 1<<32-2 {
+		return nil, fmt.Errorf("uid range %d:%d overflows", base, count)
+	}
+	return &Range{Base: uint32(base), Count: uint32(count)}, nil
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d:%d", r.Base, r.Count)
+}
+
+// Shift returns the host id the given id of the user namespace is mapped to
+func (r Range) Shift(id int) (int, error) {
+	if id < 0 || uint64(id) >= uint64(r.Count) {
+		return 0, fmt.Errorf("id %d out of uid range %s", id, r)
+	}
+	return int(r.Base) + id, nil
+}
+
+// Overlaps reports whether the two ranges have ids in common
+func (r Range) Overlaps(o Range) bool {
+	return uint64(r.Base) < uint64(o.Base)+uint64(o.Count) &&
+		uint64(o.Base) < uint64(r.Base)+uint64(r.Count)
+}
@@ -0,0 +1,71 @@
+package uid
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in string
+		r  *Range
+	}{
+		{"100000", &Range{100000, DefaultCount}},
+		{"100000:1000", &Range{100000, 1000}},
+		{"0:1", &Range{0, 1}},
+		{"", nil},
+		{"a:1", nil},
+		{"1:b", nil},
+		{"1:0", nil},
+		{"1:2:3", nil},
+		{"-1", nil},
+		{"4294967295", nil},
+		{"4294967200:100", nil},
+	}
+	for i, tt := range tests {
+		r, err := ParseRange(tt.in)
+		if tt.r == nil {
+			if err == nil {
+				t.Errorf("#%d: expected error parsing %q, got %v", i, tt.in, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if *r != *tt.r {
+			t.Errorf("#%d: got %v, want %v", i, r, tt.r)
+		}
+	}
+}
+
+func TestShift(t *testing.T) {
+	r := Range{100000, 65536}
+	if id, err := r.Shift(0); err != nil || id != 100000 {
+		t.Errorf("got %d, %v, want 100000", id, err)
+	}
+	if id, err := r.Shift(65535); err != nil || id != 165535 {
+		t.Errorf("got %d, %v, want 165535", id, err)
+	}
+	for _, id := range []int{-1, 65536} {
+		if _, err := r.Shift(id); err == nil {
+			t.Errorf("expected error shifting %d", id)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b Range
+		want bool
+	}{
+		{Range{0, 10}, Range{10, 10}, false},
+		{Range{0, 11}, Range{10, 10}, true},
+		{Range{10, 10}, Range{0, 11}, true},
+		{Range{0, 100}, Range{10, 10}, true},
+		{Range{4294967000, 295}, Range{0, 10}, false},
+	}
+	for i, tt := range tests {
+		if got := tt.a.Overlaps(tt.b); got != tt.want {
+			t.Errorf("#%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+}
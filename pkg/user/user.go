@@ -0,0 +1,200 @@
+package user
+
+//
+// Lookups of users and groups in the passwd and group databases of a rootfs
+// (e.g. the rootfs of an app), rather than the ones of the host.
+//
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// User is an entry of a passwd database
+type User struct {
+	Name  string
+	Uid   int
+	Gid   int
+	Home  string
+	Shell string
+}
+
+// Group is an entry of a group database
+type Group struct {
+	Name string
+	Gid  int
+}
+
+// UnknownUserError is returned by LookupUser when the user cannot be found
+type UnknownUserError string
+
+func (e UnknownUserError) Error() string {
+	return fmt.Sprintf("unknown user %q", string(e))
+}
+
+// UnknownGroupError is returned by LookupGroup when the group cannot be found
+type UnknownGroupError string
+
+func (e UnknownGroupError) Error() string {
+	return fmt.Sprintf("unknown group %q", string(e))
+}
+
+// LookupUser looks up the user u, either a name or a numeric uid, in the
+// /etc/passwd of the given root. If there is no such user (or no passwd
+// database at all), an UnknownUserError is returned.
+func LookupUser(root, u string) (*User, error) {
+	var found *User
+	key := keyField(u, 2)
+	err := scan(root, passwdPath, 7, func(f []string) (bool, error) {
+		if f[key] != u {
+			return false, nil
+		}
+		uid, err := strconv.Atoi(f[2])
+		if err != nil {
+			return false, fmt.Errorf("bad uid %q for user %q", f[2], f[0])
+		}
+		gid, err := strconv.Atoi(f[3])
+		if err != nil {
+			return false, fmt.Errorf("bad gid %q for user %q", f[3], f[0])
+		}
+		found = &User{
+			Name:  f[0],
+			Uid:   uid,
+			Gid:   gid,
+			Home:  f[5],
+			Shell: f[6],
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, UnknownUserError(u)
+	}
+	return found, nil
+}
+
+// LookupGroup looks up the group g, either a name or a numeric gid, in the
+// /etc/group of the given root. If there is no such group (or no group
+// database at all), an UnknownGroupError is returned.
+func LookupGroup(root, g string) (*Group, error) {
+	var found *Group
+	key := keyField(g, 2)
+	err := scan(root, groupPath, 4, func(f []string) (bool, error) {
+		if f[key] != g {
+			return false, nil
+		}
+		gid, err := strconv.Atoi(f[2])
+		if err != nil {
+			return false, fmt.Errorf("bad gid %q for group %q", f[2], f[0])
+		}
+		found = &Group{
+			Name: f[0],
+			Gid:  gid,
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, UnknownGroupError(g)
+	}
+	return found, nil
+}
+
+// LookupPath returns the owner and group of the file at path in the given
+// root, which must not be reached through symlinks
+func LookupPath(root, path string) (uid, gid int, err error) {
+	fi, err := lstat(root, path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi == nil {
+		return 0, 0, fmt.Errorf("%s: no such file", path)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("%s: cannot get owner", path)
+	}
+	return int(st.Uid), int(st.Gid), nil
+}
+
+// keyField returns the field of the database entries to match s against:
+// the one holding the numeric ID if s is numeric, the name otherwise
+func keyField(s string, idField int) int {
+	if _, err := strconv.Atoi(s); err == nil {
+		return idField
+	}
+	return 0
+}
+
+// lstat returns the FileInfo of the file at path in root, or nil if it does
+// not exist. As the rootfs is not trusted, links out of it are not followed:
+// no component of path may be a symlink.
+func lstat(root, path string) (os.FileInfo, error) {
+	fn := root
+	var fi os.FileInfo
+	for _, c := range strings.Split(strings.Trim(path, "/"), "/") {
+		var err error
+		fn = filepath.Join(fn, c)
+		fi, err = os.Lstat(fn)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("%s: symlinks are not supported", path)
+		}
+	}
+	return fi, nil
+}
+
+// scan calls match with the colon-separated fields of each entry of the
+// database at path in root, until it returns true. Entries with less than n
+// fields are skipped. A database that does not exist is empty.
+func scan(root, path string, n int, match func([]string) (bool, error)) error {
+	fi, err := lstat(root, path)
+	if err != nil || fi == nil {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(root, path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < n {
+			continue
+		}
+		ok, err := match(fields)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,145 @@
+package user
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/bash
+# comment
+
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+broken
+svc:x:1000:100::/var/lib/svc:/bin/sh
+`
+
+func newRoot(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "user-test")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	for fn, content := range files {
+		p := filepath.Join(root, fn)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("error creating dir: %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("error writing file: %v", err)
+		}
+	}
+	return root
+}
+
+func TestLookupUser(t *testing.T) {
+	root := newRoot(t, map[string]string{"etc/passwd": testPasswd})
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		in   string
+		user *User
+	}{
+		{"root", &User{"root", 0, 0, "/root", "/bin/bash"}},
+		{"0", &User{"root", 0, 0, "/root", "/bin/bash"}},
+		{"svc", &User{"svc", 1000, 100, "/var/lib/svc", "/bin/sh"}},
+		{"1000", &User{"svc", 1000, 100, "/var/lib/svc", "/bin/sh"}},
+		{"nobody", nil},
+		{"broken", nil},
+		{"42", nil},
+	}
+	for i, tt := range tests {
+		u, err := LookupUser(root, tt.in)
+		if tt.user == nil {
+			if _, ok := err.(UnknownUserError); !ok {
+				t.Errorf("#%d: want UnknownUserError, got %v, %v", i, u, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(u, tt.user) {
+			t.Errorf("#%d: got %#v, want %#v", i, u, tt.user)
+		}
+	}
+}
+
+func TestLookupUserNoPasswd(t *testing.T) {
+	root := newRoot(t, nil)
+	defer os.RemoveAll(root)
+
+	if _, err := LookupUser(root, "root"); err != UnknownUserError("root") {
+		t.Errorf("want UnknownUserError, got %v", err)
+	}
+}
+
+func TestLookupUserSymlink(t *testing.T) {
+	root := newRoot(t, nil)
+	defer os.RemoveAll(root)
+
+	if err := os.Symlink("/etc", filepath.Join(root, "etc")); err != nil {
+		t.Fatalf("error creating symlink: %v", err)
+	}
+	if _, err := LookupUser(root, "root"); err == nil {
+		t.Errorf("expected error looking up through a symlink")
+	}
+}
+
+const testGroup = `root:x:0:
+users:x:100:svc,other
+42:x:7:
+`
+
+func TestLookupGroup(t *testing.T) {
+	root := newRoot(t, map[string]string{"etc/group": testGroup})
+	defer os.RemoveAll(root)
+
+	tests := []struct {
+		in    string
+		group *Group
+	}{
+		{"root", &Group{"root", 0}},
+		{"0", &Group{"root", 0}},
+		{"users", &Group{"users", 100}},
+		{"100", &Group{"users", 100}},
+		// numeric values are gids, not names
+		{"7", &Group{"42", 7}},
+		{"42", nil},
+		{"nogroup", nil},
+	}
+	for i, tt := range tests {
+		g, err := LookupGroup(root, tt.in)
+		if tt.group == nil {
+			if _, ok := err.(UnknownGroupError); !ok {
+				t.Errorf("#%d: want UnknownGroupError, got %v, %v", i, g, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(g, tt.group) {
+			t.Errorf("#%d: got %#v, want %#v", i, g, tt.group)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	root := newRoot(t, map[string]string{"var/lib/svc/data": ""})
+	defer os.RemoveAll(root)
+
+	uid, gid, err := LookupPath(root, "/var/lib/svc/data")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uid != os.Getuid() || gid != os.Getgid() {
+		t.Errorf("got %d:%d, want %d:%d", uid, gid, os.Getuid(), os.Getgid())
+	}
+	if _, _, err := LookupPath(root, "/nonexistent"); err == nil {
+		t.Errorf("expected error for nonexistent file")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/stage0"
+)
+
+const (
+	// appArgsStart and appArgsEnd delimit the arguments of an app
+	appArgsStart = "--"
+	appArgsEnd   = "---"
+)
+
+// parseApps splits the arguments of run into the images to run, each with
+// the per-app flags and arguments following it
+func parseApps(args []string) ([]stage0.AppConfig, error) {
+	var apps []stage0.AppConfig
+	for len(args) > 0 {
+		img := args[0]
+		ac := stage0.AppConfig{Image: img}
+		var name, restart, backoff string
+		env := envMap{}
+		fs := flag.NewFlagSet(img, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.StringVar(&name, "name", "", "")
+		fs.StringVar(&ac.Exec, "exec", "", "")
+		fs.Var(&env, "env", "")
+		fs.StringVar(&ac.User, "user", "", "")
+		fs.StringVar(&ac.Group, "group", "", "")
+		fs.StringVar(&restart, "restart", "", "")
+		fs.StringVar(&backoff, "restart-backoff", "", "")
+		fs.Var(&appDeps{&ac.After, false}, "after", "")
+		fs.Var(&appDeps{&ac.After, true}, "after-ready", "")
+		if err := fs.Parse(args[1:]); err != nil {
+			return nil, fmt.Errorf("bad flags for image %s: %v", img, err)
+		}
+		if name != "" {
+			n, err := types.NewACName(name)
+			if err != nil {
+				return nil, fmt.Errorf("bad app name %q: %v", name, err)
+			}
+			ac.Name = *n
+		}
+		if len(env) > 0 {
+			ac.Env = env
+		}
+		if restart != "" || backoff != "" {
+			rp, err := parseRestartPolicy(restart, backoff)
+			if err != nil {
+				return nil, fmt.Errorf("bad restart policy for image %s: %v", img, err)
+			}
+			ac.Restart = rp
+		}
+
+		// Parse stops after the "--" terminator, if any, consuming it
+		rest := fs.Args()
+		if _, terminated := flagsEnd(fs, args[1:]); terminated {
+			ac.Args = []string{}
+			for len(rest) > 0 {
+				arg := rest[0]
+				rest = rest[1:]
+				if arg == appArgsEnd {
+					break
+				}
+				ac.Args = append(ac.Args, arg)
+			}
+		}
+
+		apps = append(apps, ac)
+		args = rest
+	}
+	return apps, nil
+}
+
+// flagsEnd goes through args the way fs.Parse does, and returns how many of
+// them the flags of fs take and whether the flags end with the appArgsStart
+// terminator, which is then included in the count. Unlike the argument
+// preceding fs.Args(), it is not fooled by a flag value of "--".
+func flagsEnd(fs *flag.FlagSet, args []string) (int, bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == appArgsStart {
+			return i + 1, true
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return i, false
+		}
+		name := strings.TrimPrefix(arg[1:], "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			// fs.Parse fails on it
+			return i, false
+		}
+		if bf, ok := f.Value.(interface {
+			IsBoolFlag() bool
+		}); ok && bf.IsBoolFlag() {
+			continue
+		}
+		// the value is the next argument, whatever it looks like
+		i++
+	}
+	return len(args), false
+}
+
+// parseRestartPolicy parses a restart policy given as POLICY[:MAXATTEMPTS]
+// (defaulting to on-failure) and a backoff duration
+func parseRestartPolicy(restart, backoff string) (*types.RestartPolicy, error) {
+	policy := "on-failure"
+	max := 0
+	if restart != "" {
+		elems := strings.SplitN(restart, ":", 2)
+		policy = elems[0]
+		if len(elems) == 2 {
+			var err error
+			if max, err = strconv.Atoi(elems[1]); err != nil {
+				return nil, fmt.Errorf("bad max attempts %q", elems[1])
+			}
+		}
+	}
+	return types.NewRestartPolicy(policy, max, backoff)
+}
+
+// appDeps implements the flag.Value interface to append the apps an app
+// starts after to a list, once they have started or once they are ready
+type appDeps struct {
+	deps  *[]types.AppDependency
+	ready bool
+}
+
+func (ad *appDeps) Set(s string) error {
+	n, err := types.NewACName(s)
+	if err != nil {
+		return err
+	}
+	*ad.deps = append(*ad.deps, types.AppDependency{App: *n, Ready: ad.ready})
+	return nil
+}
+
+func (ad *appDeps) String() string {
+	var ss []string
+	for _, d := range *ad.deps {
+		if d.Ready == ad.ready {
+			ss = append(ss, d.App.String())
+		}
+	}
+	return strings.Join(ss, ",")
+}
+
+// envMap implements the flag.Value interface to contain a set of environment
+// variables
+type envMap map[string]string
+
+func (em *envMap) Set(s string) error {
+	elems := strings.SplitN(s, "=", 2)
+	if len(elems) != 2 || elems[0] == "" {
+		return errors.New("environment variable must be of form name=value")
+	}
+	if _, ok := (*em)[elems[0]]; ok {
+		return fmt.Errorf("got multiple flags for environment variable %q", elems[0])
+	}
+	(*em)[elems[0]] = elems[1]
+	return nil
+}
+
+func (em *envMap) String() string {
+	var ss []string
+	for k, v := range *em {
+		ss = append(ss, fmt.Sprintf("%s=%s", k, v))
+	}
+	return strings.Join(ss, ",")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/stage0"
+)
+
+func TestParseApps(t *testing.T) {
+	tests := []struct {
+		in   string
+		apps []stage0.AppConfig
+	}{
+		{
+			"img",
+			[]stage0.AppConfig{{Image: "img"}},
+		},
+		{
+			"img1 img2",
+			[]stage0.AppConfig{{Image: "img1"}, {Image: "img2"}},
+		},
+		{
+			"img --name=a img --name b",
+			[]stage0.AppConfig{{Image: "img", Name: "a"}, {Image: "img", Name: "b"}},
+		},
+		{
+			"img --exec /bin/sh --env A=1 --env B=x=y --user 1000 --group users",
+			[]stage0.AppConfig{{
+				Image: "img",
+				Exec:  "/bin/sh",
+				Env:   map[string]string{"A": "1", "B": "x=y"},
+				User:  "1000",
+				Group: "users",
+			}},
+		},
+		{
+			"img1 -- -c --name --- img2 --name b -- ---",
+			[]stage0.AppConfig{
+				{Image: "img1", Args: []string{"-c", "--name"}},
+				{Image: "img2", Name: "b", Args: []string{}},
+			},
+		},
+		{
+			"img --restart on-failure:3 --restart-backoff 1s img --restart always img --restart-backoff 5s",
+			[]stage0.AppConfig{
+				{Image: "img", Restart: &types.RestartPolicy{Policy: "on-failure", MaxAttempts: 3, Backoff: "1s"}},
+				{Image: "img", Restart: &types.RestartPolicy{Policy: "always"}},
+				{Image: "img", Restart: &types.RestartPolicy{Policy: "on-failure", Backoff: "5s"}},
+			},
+		},
+		{
+			"img1 --name a img2 --after a --after-ready c img3 --name c",
+			[]stage0.AppConfig{
+				{Image: "img1", Name: "a"},
+				{Image: "img2", After: []types.AppDependency{{App: "a"}, {App: "c", Ready: true}}},
+				{Image: "img3", Name: "c"},
+			},
+		},
+		{
+			"img --exec /bin/echo -- a b",
+			[]stage0.AppConfig{{Image: "img", Exec: "/bin/echo", Args: []string{"a", "b"}}},
+		},
+		// "--" as the value of a flag does not start the arguments
+		{
+			"img1 --exec -- img2",
+			[]stage0.AppConfig{{Image: "img1", Exec: "--"}, {Image: "img2"}},
+		},
+		{
+			"img1 --exec -- -- a --- img2 --user --",
+			[]stage0.AppConfig{
+				{Image: "img1", Exec: "--", Args: []string{"a"}},
+				{Image: "img2", User: "--"},
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		apps, err := parseApps(strings.Fields(tt.in))
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(apps, tt.apps) {
+			t.Errorf("#%d: got %#v, want %#v", i, apps, tt.apps)
+		}
+	}
+}
+
+func TestParseAppsBad(t *testing.T) {
+	tests := []string{
+		"img --name Bad_Name",
+		"img --env A",
+		"img --env =1",
+		"img --env A=1 --env A=2",
+		"img --nosuchflag",
+		"img --after Bad",
+		"img --restart sometimes",
+		"img --restart always:3",
+		"img --restart on-failure:x",
+		"img --restart never --restart-backoff 1s",
+	}
+
+	for i, tt := range tests {
+		if _, err := parseApps(strings.Fields(tt)); err == nil {
+			t.Errorf("#%d: expected error for %q", i, tt)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage0"
+)
+
+var (
+	cmdRunPrepared = &Command{
+		Name:    "run-prepared",
+		Summary: "Run a container set up by rkt run",
+		Usage:   "UUID",
+		Description: `Runs the container in the foreground, the way rkt run set it up. This is
+how rkt run --detach runs containers in the background.`,
+		Run: runRunPrepared,
+	}
+)
+
+func runRunPrepared(args []string) (exit int) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "run-prepared: Must provide a container UUID\n")
+		return 1
+	}
+	cdir, _, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run-prepared: %v\n", err)
+		return 1
+	}
+	stage0.Run(stage0.Config{Debug: globalFlags.Debug}, cdir) // execs, never returns
+	return 1
+}
+
+// runDetached runs the container set up in cdir, under the rkt data
+// directory gdir, in the background with rkt run-prepared: as a transient
+// unit of the host systemd if there is one, or as a daemon otherwise. The
+// container has not necessarily started yet when runDetached returns.
+func runDetached(gdir, cdir string) error {
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		return err
+	}
+	args := []string{self, "--dir=" + gdir}
+	if globalFlags.Debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, "run-prepared", filepath.Base(cdir))
+
+	if hasSystemd() {
+		if sr, err := exec.LookPath("systemd-run"); err == nil {
+			return runAsUnit(sr, cdir, args)
+		}
+	}
+	return runAsDaemon(cdir, args)
+}
+
+// runAsUnit runs args, which run the container set up in cdir, as a
+// transient unit of the host systemd with the systemd-run binary sr, and
+// records the name of the unit in cdir
+func runAsUnit(sr, cdir string, args []string) error {
+	uuid := filepath.Base(cdir)
+	unit := "rkt-" + uuid + ".service"
+	sargs := []string{"--unit=" + unit, "--description=rkt container " + uuid}
+	if b, err := exec.Command(sr, append(sargs, args...)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemd-run: %v: %s", err, b)
+	}
+	return ioutil.WriteFile(path.UnitPath(cdir), []byte(unit), 0644)
+}
+
+// runAsDaemon runs args, which run the container set up in cdir, as a daemon
+// writing its output in cdir
+func runAsDaemon(cdir string, args []string) error {
+	output, err := os.OpenFile(path.OutputPath(cdir), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+	cmd := &exec.Cmd{
+		Path:   args[0],
+		Args:   args,
+		Stdout: output,
+		Stderr: output,
+		// in a session of its own, not to be taken down with the
+		// terminal of rkt run
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// the daemon is reparented to init once rkt run exits
+	return cmd.Process.Release()
+}
+
+// hasSystemd reports whether the host runs systemd (cf. sd_booted(3))
+func hasSystemd() bool {
+	fi, err := os.Lstat("/run/systemd/system")
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/rocket/path"
+)
+
+const testUUID = "6733c088-a507-4694-aabf-edbe4fc5266f"
+
+// writeScript writes an executable shell script with the given body to dir
+func writeScript(t *testing.T, dir, name, body string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatalf("error writing script: %v", err)
+	}
+	return p
+}
+
+func TestRunAsUnit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-detach")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cdir := filepath.Join(dir, testUUID)
+	if err := os.Mkdir(cdir, 0755); err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+
+	log := filepath.Join(dir, "log")
+	sr := writeScript(t, dir, "systemd-run", `echo "$@" > `+log+"\n")
+	if err := runAsUnit(sr, cdir, []string{"/bin/rkt", "--dir=/var/lib/rkt", "run-prepared", testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("error reading log: %v", err)
+	}
+	want := "--unit=rkt-" + testUUID + ".service --description=rkt container " + testUUID + " /bin/rkt --dir=/var/lib/rkt run-prepared " + testUUID
+	if got := strings.TrimSpace(string(b)); got != want {
+		t.Errorf("got systemd-run args %q, want %q", got, want)
+	}
+	// the unit is recorded for rkt stop and rkt status
+	if b, err := ioutil.ReadFile(path.UnitPath(cdir)); err != nil || string(b) != "rkt-"+testUUID+".service" {
+		t.Errorf("unit not recorded: %q, %v", b, err)
+	}
+
+	sr = writeScript(t, dir, "systemd-run", "echo no bus >&2; exit 1\n")
+	if err := runAsUnit(sr, cdir, []string{"/bin/rkt"}); err == nil || !strings.Contains(err.Error(), "no bus") {
+		t.Errorf("expected error with the output of systemd-run, got %v", err)
+	}
+}
+
+func TestRunAsDaemon(t *testing.T) {
+	cdir, err := ioutil.TempDir("", "rkt-detach")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(cdir)
+
+	// the daemon prints its arguments, then its PID and session ID
+	rkt := writeScript(t, cdir, "rkt", `echo "$@"
+set -- $(cat /proc/$$/stat)
+echo "$1 $6"
+`)
+	if err := runAsDaemon(cdir, []string{rkt, "run-prepared", testUUID}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var lines []string
+	for i := 0; i < 500 && len(lines) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ := ioutil.ReadFile(path.OutputPath(cdir))
+		lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+	if len(lines) != 2 {
+		t.Fatalf("unexpected daemon output %q", lines)
+	}
+	if lines[0] != "run-prepared "+testUUID {
+		t.Errorf("got daemon args %q", lines[0])
+	}
+	// the daemon leads a session of its own
+	if ids := strings.Fields(lines[1]); len(ids) != 2 || ids[0] != ids[1] {
+		t.Errorf("daemon not in a session of its own: pid and sid %q", lines[1])
+	}
+}
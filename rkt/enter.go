@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/stage0"
+)
+
+const defaultEnterCmd = "/bin/sh"
+
+var (
+	cmdEnter = &Command{
+		Name:    "enter",
+		Summary: "Run a command in a running rkt job",
+		Usage:   "UUID [APP] [CMD [ARG...]]",
+		Description: `Runs CMD (defaults to /bin/sh) as root in the namespaces of the container,
+in the rootfs and with the environment of the given app (defaults to the
+first one). The second argument is taken for APP if the container has an app
+of that name. The stage1 of the container enters it; the default one needs
+nsenter(1) on the host.`,
+		Run: runEnter,
+	}
+)
+
+func runEnter(args []string) (exit int) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "enter: Must provide a container UUID\n")
+		return 1
+	}
+
+	cdir, cm, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+	if len(cm.Apps) == 0 {
+		fmt.Fprintf(os.Stderr, "enter: container has no apps\n")
+		return 1
+	}
+	app := &cm.Apps[0]
+	args = args[1:]
+	if len(args) > 0 {
+		if n, err := types.NewACName(args[0]); err == nil {
+			if a := cm.Apps.Get(*n); a != nil {
+				app = a
+				args = args[1:]
+			}
+		}
+	}
+	if len(args) == 0 {
+		args = []string{defaultEnterCmd}
+	}
+
+	if _, err := containerPid(cdir); err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+	ep, err := stage1Entrypoint(cdir, stage0.EntrypointEnter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+	ep = append(ep, "--app="+app.Name.String(), "--")
+	ep = append(ep, args...)
+	if err := os.Chdir(cdir); err != nil {
+		fmt.Fprintf(os.Stderr, "enter: %v\n", err)
+		return 1
+	}
+	if err := syscall.Exec(ep[0], ep, os.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "enter: error execing stage1: %v\n", err)
+	}
+	return 1
+}
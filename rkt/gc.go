@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/stage0"
+)
+
+// preparingGracePeriod is how long a container directory without a container
+// manifest is assumed to be still being set up by rkt run, and one whose
+// stage1 has not started yet about to be run (e.g. by rkt run --detach)
+const preparingGracePeriod = 10 * time.Minute
+
+var (
+	cmdGC = &Command{
+		Name:    "gc",
+		Summary: "Garbage-collect rkt containers no longer running",
+		Usage:   "",
+		Description: `Removes the directories of the containers that have exited, releasing the
+uid ranges of those run with --private-users. The stage1 of each container
+first cleans up after it, if it needs to.`,
+		Run: runGC,
+	}
+)
+
+func runGC(args []string) (exit int) {
+	cdirs, err := ioutil.ReadDir(containersDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "gc: error reading containers: %v\n", err)
+		return 1
+	}
+
+	for _, fi := range cdirs {
+		if !fi.IsDir() {
+			continue
+		}
+		cdir := filepath.Join(containersDir(), fi.Name())
+		removed, users, err := gcContainer(cdir, fi)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "gc: error removing container %s: %v\n", fi.Name(), err)
+			exit = 1
+		case removed && users != "":
+			fmt.Fprintf(out, "Removed container %s, released uid range %s\n", fi.Name(), users)
+		case removed:
+			fmt.Fprintf(out, "Removed container %s\n", fi.Name())
+		}
+	}
+	out.Flush()
+	return
+}
+
+// gcContainer removes the directory cdir of a container if it is not running,
+// and reports whether it did, along with the uid range of the container if it
+// had one
+func gcContainer(cdir string, fi os.FileInfo) (removed bool, users string, err error) {
+	// containers being set up or about to run are not locked yet; stage0
+	// records the start of a container once it holds the lock, as stage1s
+	// do not all leave the same traces behind
+	if time.Since(fi.ModTime()) < preparingGracePeriod {
+		if _, err := os.Stat(path.ContainerManifestPath(cdir)); os.IsNotExist(err) {
+			return false, "", nil
+		}
+		if _, err := os.Stat(path.StartedPath(cdir)); os.IsNotExist(err) {
+			return false, "", nil
+		}
+	}
+
+	// holding the lock keeps the container from being started while it is
+	// being removed
+	l, err := lock.TryExclusiveLock(cdir)
+	if err == lock.ErrLocked {
+		return false, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	defer l.Unlock()
+
+	// stage1 may have something to clean up after the container, e.g. its
+	// network if it was killed. Containers without a private copy of their
+	// stage1 image (cf. stage1Entrypoint) are removed all the same, rather
+	// than never.
+	if _, am, err := containerStage1(cdir); err == nil {
+		if _, err := stage0.Entrypoint(am, stage0.EntrypointGC); err != stage0.ErrNoEntrypoint {
+			if _, err := os.Stat(path.Stage1ImagePath(cdir)); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "gc: no stage1 image to clean up after container %s with\n", filepath.Base(cdir))
+			} else {
+				ep, err := stage1Entrypoint(cdir, stage0.EntrypointGC)
+				if err == nil {
+					ep = append(ep, "--net-dir="+filepath.Join(globalFlags.Dir, "net"))
+					err = runStage1(cdir, ep)
+				}
+				if err != nil {
+					return false, "", fmt.Errorf("stage1 gc failed: %v", err)
+				}
+			}
+		}
+	}
+
+	// the shifted files of a container with private users go away with
+	// it, so its uid range can be picked by other containers
+	if b, err := ioutil.ReadFile(path.PrivateUsersPath(cdir)); err == nil {
+		users = strings.TrimSpace(string(b))
+	}
+	if err := os.RemoveAll(cdir); err != nil {
+		return false, "", err
+	}
+	return true, users, nil
+}
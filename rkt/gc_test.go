@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+)
+
+func TestGCContainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-gc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		desc     string
+		manifest bool
+		started  bool
+		locked   bool
+		old      bool
+		removed  bool
+	}{
+		{"being set up", false, false, false, false, false},
+		{"about to run", true, false, false, false, false},
+		{"running", true, true, true, false, false},
+		// whatever its stage1 left behind
+		{"exited", true, true, false, false, true},
+		{"never run", true, false, false, true, true},
+		{"abandoned setup", false, false, false, true, true},
+		{"running for long", true, true, true, true, false},
+	}
+	for i, tt := range tests {
+		cdir := filepath.Join(dir, tt.desc)
+		if err := os.Mkdir(cdir, 0755); err != nil {
+			t.Fatalf("error creating container dir: %v", err)
+		}
+		if tt.manifest {
+			if err := ioutil.WriteFile(path.ContainerManifestPath(cdir), []byte("{}"), 0644); err != nil {
+				t.Fatalf("error writing manifest: %v", err)
+			}
+		}
+		if tt.started {
+			if err := ioutil.WriteFile(path.StartedPath(cdir), nil, 0644); err != nil {
+				t.Fatalf("error writing start marker: %v", err)
+			}
+		}
+		if tt.old {
+			past := time.Now().Add(-2 * preparingGracePeriod)
+			if err := os.Chtimes(cdir, past, past); err != nil {
+				t.Fatalf("error aging container dir: %v", err)
+			}
+		}
+		var l *lock.DirLock
+		if tt.locked {
+			if l, err = lock.ExclusiveLock(cdir); err != nil {
+				t.Fatalf("error locking container dir: %v", err)
+			}
+		}
+		fi, err := os.Stat(cdir)
+		if err != nil {
+			t.Fatalf("error reading container dir: %v", err)
+		}
+
+		removed, _, err := gcContainer(cdir, fi)
+		if l != nil {
+			l.Unlock()
+		}
+		if err != nil {
+			t.Errorf("#%d (%s): unexpected error: %v", i, tt.desc, err)
+			continue
+		}
+		if removed != tt.removed {
+			t.Errorf("#%d (%s): expected removed=%t, got %t", i, tt.desc, tt.removed, removed)
+		}
+		if _, err := os.Stat(cdir); os.IsNotExist(err) != tt.removed {
+			t.Errorf("#%d (%s): container directory removed=%t", i, tt.desc, os.IsNotExist(err))
+		}
+	}
+}
+
+func TestGCStage1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-gc")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	const manifest = `{"acKind": "AppManifest", "acVersion": "0.1.0", "name": "example.com/stage1", "os": "linux", "arch": "amd64", "exec": ["/init", "run"],
+		"annotations": {"coreos.com/rkt/stage1/gc": "/gc", "coreos.com/rkt/stage1/gc-version": "1"}}`
+	log := filepath.Join(dir, "log")
+
+	tests := []struct {
+		desc    string
+		gc      string // the gc entrypoint of the private copy of stage1, if any
+		removed bool
+		ran     bool
+	}{
+		{"cleaned up", "#!/bin/sh\necho gc >> " + log + "\n", true, true},
+		{"failing to clean up", "#!/bin/sh\necho gc >> " + log + "\nexit 1\n", false, true},
+		// e.g. set up by an older rkt
+		{"without stage1 copy", "", true, false},
+	}
+	for i, tt := range tests {
+		os.Remove(log)
+		cdir := filepath.Join(dir, tt.desc)
+		if err := os.Mkdir(cdir, 0755); err != nil {
+			t.Fatalf("error creating container dir: %v", err)
+		}
+		for fn, b := range map[string]string{
+			path.ContainerManifestPath(cdir): "{}",
+			path.StartedPath(cdir):           "",
+			path.Stage1IDPath(cdir):          "sha256-0123",
+			path.Stage1ManifestPath(cdir):    manifest,
+		} {
+			if err := ioutil.WriteFile(fn, []byte(b), 0644); err != nil {
+				t.Fatalf("error writing container file: %v", err)
+			}
+		}
+		if tt.gc != "" {
+			s1 := path.Stage1ImagePath(cdir)
+			if err := os.MkdirAll(filepath.Join(s1, "rootfs"), 0755); err != nil {
+				t.Fatalf("error creating stage1 copy: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(s1, "app"), []byte(manifest), 0644); err != nil {
+				t.Fatalf("error writing stage1 manifest: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(s1, "rootfs/gc"), []byte(tt.gc), 0755); err != nil {
+				t.Fatalf("error writing stage1 gc: %v", err)
+			}
+		}
+		fi, err := os.Stat(cdir)
+		if err != nil {
+			t.Fatalf("error reading container dir: %v", err)
+		}
+
+		removed, _, err := gcContainer(cdir, fi)
+		if removed != tt.removed || (err == nil) != tt.removed {
+			t.Errorf("#%d (%s): expected removed=%t, got %t (%v)", i, tt.desc, tt.removed, removed, err)
+		}
+		if _, err := os.Stat(log); (err == nil) != tt.ran {
+			t.Errorf("#%d (%s): expected stage1 gc run=%t", i, tt.desc, tt.ran)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage0"
+)
+
+var (
+	flagFollow bool
+	cmdLogs    = &Command{
+		Name:    "logs",
+		Summary: "Show the logs of a rkt job",
+		Usage:   "[--follow] UUID [APP]",
+		Description: `Prints the output of the apps of the container, or of the given app only,
+wherever "rkt run --log-target" kept it. It needs journalctl(1) on the host.`,
+		Run: runLogs,
+	}
+)
+
+func init() {
+	cmdLogs.Flags.BoolVar(&flagFollow, "follow", false, "keep printing the logs as they are written")
+}
+
+func runLogs(args []string) (exit int) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "logs: Must provide a container UUID and optionally an app name\n")
+		return 1
+	}
+
+	cdir, cm, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logs: %v\n", err)
+		return 1
+	}
+
+	apps := cm.Apps
+	if len(args) == 2 {
+		n, err := types.NewACName(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "logs: invalid app name %q: %v\n", args[1], err)
+			return 1
+		}
+		a := cm.Apps.Get(*n)
+		if a == nil {
+			fmt.Fprintf(os.Stderr, "logs: no app %s in container\n", n)
+			return 1
+		}
+		apps = []schema.App{*a}
+	}
+
+	b, err := ioutil.ReadFile(path.LogTargetPath(cdir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logs: unable to determine where the logs are: %v\n", err)
+		return 1
+	}
+	var dir string
+	switch target := string(b); target {
+	case stage0.LogTargetFiles:
+		dir = path.JournalPath(cdir)
+	case stage0.LogTargetJournal:
+		dir = path.HostJournalPath(cm.UUID)
+	default:
+		fmt.Fprintf(os.Stderr, "logs: unknown log target %q\n", target)
+		return 1
+	}
+
+	bin, err := exec.LookPath("journalctl")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logs: %v\n", err)
+		return 1
+	}
+	jargs := []string{bin, "--directory=" + dir}
+	if flagFollow {
+		jargs = append(jargs, "--follow")
+	}
+	// matches on the same field are alternatives, on different fields
+	// all have to hold
+	jargs = append(jargs, "CONTAINER_UUID="+cm.UUID.String())
+	for _, a := range apps {
+		jargs = append(jargs, "APP_NAME="+a.Name.String())
+	}
+	if err := syscall.Exec(bin, jargs, os.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "logs: error execing journalctl: %v\n", err)
+	}
+	return 1
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"text/tabwriter"
 )
 
@@ -12,6 +13,8 @@ const (
 	cliDescription = "rocket, the application container runner"
 
 	defaultDataDir = "/var/lib/rkt"
+
+	containersDirName = "containers"
 )
 
 var (
@@ -47,9 +50,15 @@ func init() {
 	out.Init(os.Stdout, 0, 8, 1, '\t', 0)
 	commands = []*Command{
 		cmdHelp,
+		cmdEnter,
 		cmdFetch,
+		cmdGC,
+		cmdKill,
+		cmdLogs,
 		cmdStatus,
 		cmdRun,
+		cmdRunPrepared,
+		cmdStop,
 		cmdVersion,
 	}
 }
@@ -84,6 +93,11 @@ func main() {
 	os.Exit(cmd.Run(cmd.Flags.Args()))
 }
 
+// containersDir returns the directory holding the containers
+func containersDir() string {
+	return filepath.Join(globalFlags.Dir, containersDirName)
+}
+
 func getAllFlags() (flags []*flag.Flag) {
 	return getFlags(globalFlagset)
 }
@@ -11,27 +11,82 @@ import (
 
 	"github.com/coreos/rocket/app-container/schema/types"
 	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/pkg/portfwd"
+	"github.com/coreos/rocket/pkg/uid"
 	"github.com/coreos/rocket/stage0"
 )
 
 var (
-	flagStage1Init   string
-	flagStage1Rootfs string
+	flagStage1Image  string
 	flagVolumes      volumeMap
+	flagPrivateNet   bool
+	flagPorts        portList
+	flagPrivateUsers privateUsers
+	flagLogTarget    string
+	flagDetach       bool
 	cmdRun           = &Command{
 		Name:    "run",
 		Summary: "Run image(s) in an application container in rocket",
-		Usage:   "[--volume LABEL:SOURCE] IMAGE...",
+		Usage:   "[--stage1-image HASH|PATH|NAME] [--volume LABEL:SOURCE] [--private-net] [--port NAME:HOSTPORT] [--private-users[=BASE[:COUNT]]] [--log-target files|journal] [--detach] IMAGE [APPFLAGS] [-- ARG... ---]...",
 		Description: `IMAGE should be a string referencing an image; either a hash, local file on disk, or URL.
-They will be checked in that order and the first match will be used.`,
+They will be checked in that order and the first match will be used.
+
+Each IMAGE may be followed by flags (APPFLAGS) applying to the app it runs only:
+  --name NAME		name of the app in the container (defaults to the
+			image's app name); the same image can run several
+			times under different names
+  --exec PATH		executable to run instead of the image's one; the
+			image's arguments are dropped
+  --env NAME=VALUE	environment variable to set, on top of the image's
+			environment (can be repeated)
+  --user USER		user to run the app as
+  --group GROUP		group to run the app as
+  --restart POLICY[:MAXATTEMPTS]
+			restart the app once it has exited: "never" (the
+			default), "on-failure" (at most MAXATTEMPTS times,
+			if given) or "always"
+  --restart-backoff DURATION
+			delay before restarting the app (e.g. "10s")
+  --after APP		start the app after the app named APP has started
+			(can be repeated); the app is stopped if APP stops
+  --after-ready APP	same as --after, but wait for APP to notify that it
+			is ready, as with sd_notify(3)
+The main app is the first one: the container exits with it once it has
+failed (and is not restarted anymore), while the other apps can fail on their
+own.
+The arguments between "--" and "---" (or the end of the command line) replace
+the arguments of the image's exec.
+
+The container runs with the stage1 image given by --stage1-image, found the
+same way as the images of the apps, except that a name is looked up among the
+images in the store only: stage1 images are never fetched, as they run as root
+on the host. Its app manifest declares the entrypoint of stage1.
+
+With --private-users, root in the container is an unprivileged user on the
+host: the uids 0 to COUNT-1 of the container are mapped to the host uids
+BASE to BASE+COUNT-1 (COUNT defaults to 65536), and the files of the
+container are owned accordingly. Without a range, one that no other
+container uses is picked; it is released by "rkt gc".
+
+The output of the apps is shown on the terminal and logged, in journal files
+in the container directory with --log-target=files (the default), or in the
+host journal with --log-target=journal; "rkt logs" reads it back.
+
+With --detach, the container runs in the background and its UUID is printed.
+It runs as a transient unit of the host systemd, if there is one, or as a
+child process of init otherwise.`,
 		Run: runRun,
 	}
 )
 
 func init() {
-	cmdRun.Flags.StringVar(&flagStage1Init, "stage1-init", "", "path to stage1 binary override")
-	cmdRun.Flags.StringVar(&flagStage1Rootfs, "stage1-rootfs", "", "path to stage1 rootfs tarball override")
+	cmdRun.Flags.StringVar(&flagStage1Image, "stage1-image", "", "stage1 image to run the container with: a hash, a path or a name (defaults to "+defaultStage1Image+" next to the rkt binary)")
 	cmdRun.Flags.Var(&flagVolumes, "volume", "volumes to mount into the shared container environment")
+	cmdRun.Flags.BoolVar(&flagPrivateNet, "private-net", false, "give container a private network")
+	cmdRun.Flags.Var(&flagPorts, "port", "ports to publish on the host (requires --private-net)")
+	cmdRun.Flags.Var(&flagPrivateUsers, "private-users", "run the container in a user namespace, mapped to the given uid range (or a free one)")
+	cmdRun.Flags.StringVar(&flagLogTarget, "log-target", stage0.LogTargetFiles, "where to log the output of the apps: files or journal")
+	cmdRun.Flags.BoolVar(&flagDetach, "detach", false, "run the container in the background")
 	flagVolumes = volumeMap{}
 }
 
@@ -86,30 +141,60 @@ func runRun(args []string) (exit int) {
 		}
 	}
 
+	apps, err := parseApps(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: %v\n", err)
+		return 1
+	}
+	var imgs []string
+	for _, ac := range apps {
+		imgs = append(imgs, ac.Image)
+	}
+
 	ds := cas.NewStore(globalFlags.Dir)
-	imgs, err := findImages(args, ds)
+	imgs, err = findImages(imgs, ds)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s", err)
 		return 1
 	}
+	for i := range apps {
+		apps[i].Image = imgs[i]
+	}
+	s1img, err := findStage1Image(flagStage1Image, ds)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "run: error finding stage1 image: %v\n", err)
+		return 1
+	}
 
-	// TODO(jonboulle): use rkt/path
-	cdir := filepath.Join(gdir, "containers")
+	cdir := filepath.Join(gdir, containersDirName)
 	cfg := stage0.Config{
 		Store:         ds,
 		ContainersDir: cdir,
 		Debug:         globalFlags.Debug,
-		Stage1Init:    flagStage1Init,
-		Stage1Rootfs:  flagStage1Rootfs,
-		Images:        imgs,
+		Stage1Image:   s1img,
+		Apps:          apps,
 		Volumes:       flagVolumes,
+		PrivateNet:    flagPrivateNet,
+		NetDir:        filepath.Join(gdir, "net"),
+		Ports:         flagPorts,
+		PrivateUsers:  flagPrivateUsers.r,
+		LogTarget:     flagLogTarget,
 	}
 	cdir, err = stage0.Setup(cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "run: error setting up stage0: %v\n", err)
 		return 1
 	}
-	stage0.Run(cdir, cfg.Debug) // execs, never returns
+	if flagDetach {
+		if err := runDetached(gdir, cdir); err != nil {
+			fmt.Fprintf(os.Stderr, "run: error detaching container: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(out, filepath.Base(cdir))
+		out.Flush()
+		return 0
+	}
+	stage0.Run(cfg, cdir) // execs, never returns
 	return 1
 }
 
@@ -137,3 +222,66 @@ func (vm *volumeMap) String() string {
 	}
 	return strings.Join(ss, ",")
 }
+
+// privateUsers implements the flag.Value interface to contain the uid range
+// the container's user namespace is mapped to. A zero range is one to pick.
+type privateUsers struct {
+	r *uid.Range
+}
+
+func (pu *privateUsers) Set(s string) error {
+	switch s {
+	case "true":
+		pu.r = &uid.Range{}
+	case "false":
+		pu.r = nil
+	default:
+		r, err := uid.ParseRange(s)
+		if err != nil {
+			return err
+		}
+		pu.r = r
+	}
+	return nil
+}
+
+func (pu *privateUsers) String() string {
+	if pu.r == nil {
+		return "false"
+	}
+	if pu.r.Count == 0 {
+		return "true"
+	}
+	return pu.r.String()
+}
+
+// IsBoolFlag allows --private-users to be given without a range
+func (pu *privateUsers) IsBoolFlag() bool {
+	return true
+}
+
+// portList implements the flag.Value interface to contain a set of requests
+// to publish app ports (by name) on host ports
+type portList []portfwd.Request
+
+func (pl *portList) Set(s string) error {
+	r, err := portfwd.Parse(s)
+	if err != nil {
+		return err
+	}
+	for _, p := range *pl {
+		if p.Name.Equals(r.Name) {
+			return fmt.Errorf("got multiple flags for port %q", r.Name)
+		}
+	}
+	*pl = append(*pl, *r)
+	return nil
+}
+
+func (pl *portList) String() string {
+	var ss []string
+	for _, p := range *pl {
+		ss = append(ss, p.String())
+	}
+	return strings.Join(ss, ",")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/cas"
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage0"
+)
+
+// defaultStage1Image is the stage1 image containers run with when none is
+// given: a file next to the rkt binary, where ./build puts it
+const defaultStage1Image = "stage1.aci"
+
+// findStage1Image returns the hash of the stage1 image img, making sure the
+// store ds has it. img is either the hash of an image in the store, the path
+// of an image file to import or the name of an image in the store. Unlike the
+// images of the apps, it is never discovered nor fetched: stage1 runs as root
+// on the host, and fetched images are not verified. An empty img stands for
+// the default stage1 image.
+func findStage1Image(img string, ds *cas.Store) (string, error) {
+	if img == "" {
+		self, err := os.Readlink("/proc/self/exe")
+		if err != nil {
+			return "", err
+		}
+		img = filepath.Join(filepath.Dir(self), defaultStage1Image)
+	}
+
+	if _, err := types.NewHash(img); err == nil {
+		rs, err := ds.ReadStream(img)
+		if err != nil {
+			return "", fmt.Errorf("no image %s in the store", img)
+		}
+		rs.Close()
+		return img, nil
+	}
+	if _, err := os.Stat(img); err == nil {
+		imgs, err := findImages([]string{img}, ds)
+		if err != nil {
+			return "", err
+		}
+		return imgs[0], nil
+	}
+	a := cas.NewApp(img)
+	if err := ds.ReadIndex(a); err != nil {
+		return "", fmt.Errorf("%s is neither a file nor the name of an image in the store (stage1 images are not fetched)", img)
+	}
+	return a.Blob, nil
+}
+
+// containerStage1 returns the hash and the app manifest of the stage1 image
+// the container in cdir runs with, as recorded by stage0
+func containerStage1(cdir string) (string, *schema.AppManifest, error) {
+	id, err := ioutil.ReadFile(path.Stage1IDPath(cdir))
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading stage1 image hash: %v", err)
+	}
+	am, err := stage0.Stage1Manifest(cdir)
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(string(id)), am, nil
+}
+
+// stage1Entrypoint returns the command line of the given entrypoint of the
+// stage1 of the container in cdir, with the flags all entrypoints take. As the
+// apps can write to the stage1 rootfs of the container, the entrypoint runs
+// from the private copy of the stage1 image stage0 kept in cdir.
+func stage1Entrypoint(cdir, name string) ([]string, error) {
+	ep, err := stage0.ImageEntrypoint(path.Stage1ImagePath(cdir), name)
+	if err == stage0.ErrNoEntrypoint {
+		_, am, err := containerStage1(cdir)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("stage1 %s of the container does not implement %s", am.Name, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ep = append(ep, "--uuid="+filepath.Base(cdir))
+	if globalFlags.Debug {
+		ep = append(ep, "--debug")
+	}
+	return ep, nil
+}
+
+// runStage1 runs the command line of a stage1 entrypoint (cf.
+// stage1Entrypoint) in the container directory cdir, and waits for it
+func runStage1(cdir string, args []string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = cdir
+	if b, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(b))
+	}
+	return nil
+}
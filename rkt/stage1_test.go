@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/rocket/cas"
+)
+
+// writeTestImage writes an image with the app manifest of the given app name
+// to the file at path
+func writeTestImage(t *testing.T, path, name string) {
+	am := `{"acKind": "AppManifest", "acVersion": "0.1.0", "name": "` + name +
+		`", "os": "linux", "arch": "amd64", "exec": ["/init"]}`
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("error creating image: %v", err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	if err := tw.WriteHeader(&tar.Header{Name: "app", Mode: 0644, Size: int64(len(am))}); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	if _, err := tw.Write([]byte(am)); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+}
+
+func TestFindStage1Image(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-stage1")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+
+	// names are only looked up in the store
+	if _, err := findStage1Image("example.com/stage1", ds); err == nil {
+		t.Fatalf("expected error finding stage1 image not in the store")
+	}
+
+	img := filepath.Join(dir, "stage1.aci")
+	writeTestImage(t, img, "example.com/stage1")
+	key, err := findStage1Image(img, ds)
+	if err != nil {
+		t.Fatalf("unexpected error importing stage1 image: %v", err)
+	}
+
+	for i, in := range []string{key, "example.com/stage1"} {
+		got, err := findStage1Image(in, ds)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if got != key {
+			t.Errorf("#%d: got %q, want %q", i, got, key)
+		}
+	}
+
+	for i, in := range []string{
+		"sha256-0000000000000000000000000000000000000000000000000000000000000000",
+		"example.com/other",
+		"https://example.com/stage1.aci",
+	} {
+		if got, err := findStage1Image(in, ds); err == nil {
+			t.Errorf("#%d: expected error finding %q, got %q", i, in, got)
+		}
+	}
+}
@@ -1,17 +1,116 @@
 package main
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+)
 
 var (
 	cmdStatus = &Command{
 		Name:    "status",
 		Summary: "Check the status of a rkt job",
 		Usage:   "UUID",
-		Run:     runStatus,
+		Description: `Prints the state of the container (running or exited), the stage1 image
+it runs with, and the exit status of each of its apps that has exited.`,
+		Run: runStatus,
 	}
 )
 
 func runStatus(args []string) (exit int) {
-	fmt.Println("Not implemented.")
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "status: Must provide a container UUID\n")
+		return 1
+	}
+
+	cdir, cm, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: %v\n", err)
+		return 1
+	}
+
+	running, err := lock.IsLocked(cdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "status: unable to determine container state: %v\n", err)
+		return 1
+	}
+	if running {
+		fmt.Fprintln(out, "state=running")
+		if pid, err := ioutil.ReadFile(path.PidPath(cdir)); err == nil {
+			fmt.Fprintf(out, "pid=%s\n", strings.TrimSpace(string(pid)))
+		}
+		if unit, err := ioutil.ReadFile(path.UnitPath(cdir)); err == nil {
+			fmt.Fprintf(out, "unit=%s\n", unit)
+		}
+	} else {
+		fmt.Fprintln(out, "state=exited")
+	}
+	if s1, am, err := containerStage1(cdir); err == nil {
+		fmt.Fprintf(out, "stage1=%s\nstage1-name=%s\n", s1, am.Name)
+	}
+
+	for _, app := range cm.Apps {
+		fmt.Fprintf(out, "app-%s=%s\n", app.Name, appStatus(cdir, app.Name))
+	}
+	out.Flush()
 	return
 }
+
+// loadContainer finds the directory of the container with the given UUID and
+// reads its container runtime manifest
+func loadContainer(id string) (string, *schema.ContainerRuntimeManifest, error) {
+	cuuid, err := types.NewUUID(id)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid UUID %q: %v", id, err)
+	}
+	cdir := filepath.Join(containersDir(), cuuid.String())
+	b, err := ioutil.ReadFile(path.ContainerManifestPath(cdir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil, fmt.Errorf("no container with UUID %s", cuuid)
+		}
+		return "", nil, fmt.Errorf("error reading container manifest: %v", err)
+	}
+	cm := &schema.ContainerRuntimeManifest{}
+	if err := json.Unmarshal(b, cm); err != nil {
+		return "", nil, fmt.Errorf("error unmarshaling container manifest: %v", err)
+	}
+	return cdir, cm, nil
+}
+
+// containerPid returns the PID of the process running the container in cdir
+// (e.g. systemd-nspawn), or an error if the container is not running
+func containerPid(cdir string) (int, error) {
+	running, err := lock.IsLocked(cdir)
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine container state: %v", err)
+	}
+	if !running {
+		return 0, errors.New("container is not running")
+	}
+	b, err := ioutil.ReadFile(path.PidPath(cdir))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read container pid (is it still starting?): %v", err)
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// appStatus returns the exit status of an app as recorded by stage1, or "-"
+// if the app has not exited (yet)
+func appStatus(cdir string, appName types.ACName) string {
+	b, err := ioutil.ReadFile(path.AppStatusPath(cdir, appName))
+	if err != nil {
+		return "-"
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/proc"
+	"github.com/coreos/rocket/stage0"
+)
+
+// killTimeout is how long a killed container is waited for
+const killTimeout = 5 * time.Second
+
+var (
+	flagStopTimeout time.Duration
+	cmdStop         = &Command{
+		Name:    "stop",
+		Summary: "Stop a running rkt job",
+		Usage:   "[--timeout DURATION] UUID",
+		Description: `Stops the apps of the container, running their pre-stop and post-stop
+handlers, and records their exit statuses. The container is killed if it is
+still running once the timeout has passed.`,
+		Run: runStop,
+	}
+
+	flagKillSignal string
+	flagKillApp    string
+	cmdKill        = &Command{
+		Name:    "kill",
+		Summary: "Send a signal to the apps of a running rkt job",
+		Usage:   "[--signal SIGNAL] [--app NAME] UUID",
+		Description: `Sends the signal (SIGTERM by default; a name such as "HUP" or "SIGHUP", or a
+number) to the main process of the given app, or of all the apps of the
+container.`,
+		Run: runKill,
+	}
+)
+
+func init() {
+	cmdStop.Flags.DurationVar(&flagStopTimeout, "timeout", 30*time.Second, "how long to wait for the apps to stop before killing the container")
+	cmdKill.Flags.StringVar(&flagKillSignal, "signal", "SIGTERM", "signal to send")
+	cmdKill.Flags.StringVar(&flagKillApp, "app", "", "app to send the signal to, rather than all of them")
+}
+
+func runStop(args []string) (exit int) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "stop: Must provide a container UUID\n")
+		return 1
+	}
+
+	cdir, _, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+	if _, err := containerPid(cdir); err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+
+	// stage1 stops the apps, records their exit statuses and exits
+	timeout := flagStopTimeout
+	ep, err := stage1Entrypoint(cdir, stage0.EntrypointStop)
+	if err == nil {
+		err = runStage1(cdir, ep)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stop: unable to stop the apps, killing the container: %v\n", err)
+		timeout = 0
+	}
+	if exited, err := waitExited(cdir, timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	} else if exited {
+		fmt.Fprintf(out, "Stopped container %s\n", args[0])
+		out.Flush()
+		return 0
+	}
+
+	// the processes of the container go away with its init
+	ipid, err := stage1Pid(cdir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+	if err := syscall.Kill(ipid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		fmt.Fprintf(os.Stderr, "stop: error killing container: %v\n", err)
+		return 1
+	}
+	if exited, err := waitExited(cdir, killTimeout); err != nil || !exited {
+		fmt.Fprintf(os.Stderr, "stop: container still running after being killed (%v)\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "Killed container %s\n", args[0])
+	out.Flush()
+	return 0
+}
+
+func runKill(args []string) (exit int) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "kill: Must provide a container UUID\n")
+		return 1
+	}
+	sig, err := parseSignal(flagKillSignal)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		return 1
+	}
+
+	cdir, cm, err := loadContainer(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		return 1
+	}
+	if _, err := containerPid(cdir); err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		return 1
+	}
+	ep, err := stage1Entrypoint(cdir, stage0.EntrypointKill)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		return 1
+	}
+	ep = append(ep, "--signal="+strconv.Itoa(int(sig)))
+	if flagKillApp != "" {
+		n, err := types.NewACName(flagKillApp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kill: invalid app name %q: %v\n", flagKillApp, err)
+			return 1
+		}
+		a := cm.Apps.Get(*n)
+		if a == nil {
+			fmt.Fprintf(os.Stderr, "kill: no app %s in container\n", n)
+			return 1
+		}
+		ep = append(ep, "--app="+a.Name.String())
+	}
+	if err := runStage1(cdir, ep); err != nil {
+		fmt.Fprintf(os.Stderr, "kill: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// stage1Pid returns the PID of the init of the running container in cdir
+func stage1Pid(cdir string) (int, error) {
+	pid, err := containerPid(cdir)
+	if err != nil {
+		return 0, err
+	}
+	ipid, err := proc.ChildPid(pid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find the init of the container: %v", err)
+	}
+	return ipid, nil
+}
+
+// waitExited waits for at most timeout for the container in cdir to exit,
+// and reports whether it did
+func waitExited(cdir string, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := lock.IsLocked(cdir)
+		if err != nil {
+			return false, fmt.Errorf("unable to determine container state: %v", err)
+		}
+		if !running {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// signals maps the names of the signals that can be sent to apps to their
+// numbers
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses a signal given as a name, with or without the "SIG"
+// prefix, or a number
+func parseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n >= 65 {
+			return 0, fmt.Errorf("bad signal number %d", n)
+		}
+		return syscall.Signal(n), nil
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(s), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", s)
+	}
+	return sig, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want syscall.Signal
+	}{
+		{"TERM", syscall.SIGTERM},
+		{"SIGHUP", syscall.SIGHUP},
+		{"sigusr1", syscall.SIGUSR1},
+		{"9", syscall.SIGKILL},
+	}
+	for i, tt := range tests {
+		got, err := parseSignal(tt.in)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		} else if got != tt.want {
+			t.Errorf("#%d: got %v, want %v", i, got, tt.want)
+		}
+	}
+
+	for i, in := range []string{"", "SIG", "BOGUS", "0", "-1", "65"} {
+		if _, err := parseSignal(in); err == nil {
+			t.Errorf("#%d: got no error for %q", i, in)
+		}
+	}
+}
@@ -9,9 +9,6 @@ package stage0
 
 import (
 	"archive/tar"
-	"bytes"
-	"compress/bzip2"
-	"compress/gzip"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
@@ -20,33 +17,81 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/coreos/rocket/Godeps/_workspace/src/code.google.com/p/go-uuid/uuid"
-	"github.com/coreos/rocket/app-container/aci"
 	"github.com/coreos/rocket/app-container/schema"
 	"github.com/coreos/rocket/app-container/schema/types"
 	"github.com/coreos/rocket/cas"
 	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/lock"
+	"github.com/coreos/rocket/pkg/portfwd"
 	ptar "github.com/coreos/rocket/pkg/tar"
+	"github.com/coreos/rocket/pkg/uid"
 	"github.com/coreos/rocket/version"
-
-	"github.com/coreos/rocket/stage0/stage1_init"
-	"github.com/coreos/rocket/stage0/stage1_rootfs"
 )
 
+// Where the logs of the apps are kept (cf. Config.LogTarget)
 const (
-	initPath = "stage1/init"
+	LogTargetFiles   = "files"   // journal files in the container directory
+	LogTargetJournal = "journal" // the host journal
 )
 
 type Config struct {
 	Store         *cas.Store
 	ContainersDir string // root directory for rocket containers
-	Stage1Init    string // binary to be execed as stage1
-	Stage1Rootfs  string // compressed bundle containing a rootfs for stage1
+	Stage1Image   string // hash of the stage1 image in the store
 	Debug         bool
-	Images        []string          // application images
+	Apps          []AppConfig       // application images, and how to run them
 	Volumes       map[string]string // map of volumes that rocket can provide to applications
+	PrivateNet    bool              // whether the container gets its own network namespace
+	NetDir        string            // directory holding network state (e.g. IP leases)
+	Ports         []portfwd.Request // ports of the apps to publish on the host
+	// PrivateUsers, if set, is the uid range the container's user namespace
+	// is mapped to; a zero Count means any free range
+	PrivateUsers *uid.Range
+	LogTarget    string // where the logs of the apps are kept; files if empty
+}
+
+// AppConfig describes an app of the container: the image it runs and, if set,
+// the overrides of the app manifest of the image. In particular the name it is
+// given in the container overrides the one in the image, so the same image
+// can be run several times in a container under different names.
+type AppConfig struct {
+	Image string            // hash of the image in the store
+	Name  types.ACName      // app name override
+	Exec  string            // executable override; the image arguments are dropped
+	Args  []string          // arguments override, if non-nil
+	Env   map[string]string // environment variables to add or override
+	User  string            // user override
+	Group string            // group override
+	// Restart is the restart policy of the app, if any
+	Restart *types.RestartPolicy
+	// After lists the apps of the container the app starts after
+	After []types.AppDependency
+}
+
+// exec returns the exec of the app given the one of its image, or nil if the
+// image exec is not overridden
+func (ac AppConfig) exec(imgExec []string) ([]string, error) {
+	if ac.Exec == "" && ac.Args == nil {
+		return nil, nil
+	}
+	exec := imgExec
+	if ac.Exec != "" {
+		exec = []string{ac.Exec}
+	}
+	if len(exec) == 0 {
+		return nil, fmt.Errorf("error: arguments given but the image has no exec")
+	}
+	if !filepath.IsAbs(exec[0]) {
+		return nil, fmt.Errorf("error: exec %q is not an absolute path", exec[0])
+	}
+	if ac.Args != nil {
+		exec = append([]string{exec[0]}, ac.Args...)
+	}
+	return exec, nil
 }
 
 func init() {
@@ -73,41 +118,18 @@ func Setup(cfg Config) (string, error) {
 		return "", fmt.Errorf("error creating directory: %v", err)
 	}
 
-	log.Printf("Unpacking stage1 rootfs")
-	if cfg.Stage1Rootfs != "" {
-		if err = unpackRootfs(cfg.Stage1Rootfs, rktpath.Stage1RootfsPath(dir)); err != nil {
-			return "", fmt.Errorf("error unpacking rootfs: %v", err)
-		}
-	} else {
-		if err = unpackBuiltinRootfs(rktpath.Stage1RootfsPath(dir)); err != nil {
-			return "", fmt.Errorf("error unpacking rootfs: %v", err)
-		}
-	}
-
-	log.Printf("Writing stage1 init")
-	var in io.Reader
-	if cfg.Stage1Init != "" {
-		in, err = os.Open(cfg.Stage1Init)
+	if cfg.PrivateUsers != nil {
+		r, err := setupPrivateUsers(cfg, dir)
 		if err != nil {
-			return "", fmt.Errorf("error loading stage1 init binary: %v", err)
+			return "", fmt.Errorf("error setting up private users: %v", err)
 		}
-	} else {
-		init_bin, err := stage1_init.Asset("s1init")
-		if err != nil {
-			return "", fmt.Errorf("error accessing stage1 init bindata: %v", err)
-		}
-		in = bytes.NewBuffer(init_bin)
-	}
-	fn := filepath.Join(dir, initPath)
-	out, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY, 0555)
-	if err != nil {
-		return "", fmt.Errorf("error opening stage1 init for writing: %v", err)
-	}
-	if _, err := io.Copy(out, in); err != nil {
-		return "", fmt.Errorf("error writing stage1 init: %v", err)
+		log.Printf("Mapping the container users to the uid range %s", r)
+		cfg.PrivateUsers = r
 	}
-	if err := out.Close(); err != nil {
-		return "", fmt.Errorf("error closing stage1 init: %v", err)
+
+	log.Printf("Setting up stage1 image %s", cfg.Stage1Image)
+	if err := setupStage1Image(cfg, dir); err != nil {
+		return "", fmt.Errorf("error setting up stage1 image: %v", err)
 	}
 
 	log.Printf("Wrote filesystem to %s\n", dir)
@@ -124,25 +146,39 @@ func Setup(cfg Config) (string, error) {
 	}
 	cm.ACVersion = *v
 
-	for _, img := range cfg.Images {
+	var apps []*schema.AppManifest
+	for _, ac := range cfg.Apps {
+		img := ac.Image
 		h, err := types.NewHash(img)
 		if err != nil {
 			return "", fmt.Errorf("error: bad image hash %q: %v", img, err)
 		}
-		am, err := setupImage(cfg, img, *h, dir)
+		am, name, err := setupImage(cfg, ac, *h, dir)
 		if err != nil {
 			return "", fmt.Errorf("error setting up image %s: %v", img, err)
 		}
-		if cm.Apps.Get(am.Name) != nil {
-			return "", fmt.Errorf("error: multiple apps with name %s", am.Name)
+		exec, err := ac.exec(am.Exec)
+		if err != nil {
+			return "", fmt.Errorf("error setting up app %s: %v", name, err)
 		}
 		a := schema.App{
-			Name:        am.Name,
-			ImageID:     *h,
-			Isolators:   am.Isolators,
-			Annotations: am.Annotations,
+			Name:          name,
+			ImageID:       *h,
+			Exec:          exec,
+			Environment:   ac.Env,
+			User:          ac.User,
+			Group:         ac.Group,
+			RestartPolicy: ac.Restart,
+			After:         ac.After,
+			Isolators:     am.Isolators,
+			Annotations:   am.Annotations,
 		}
 		cm.Apps = append(cm.Apps, a)
+		apps = append(apps, am)
+	}
+
+	if err := checkPorts(cfg, apps); err != nil {
+		return "", err
 	}
 
 	var sVols []types.Volume
@@ -166,146 +202,362 @@ func Setup(cfg Config) (string, error) {
 		return "", fmt.Errorf("error marshalling container manifest: %v", err)
 	}
 
+	logTarget := cfg.LogTarget
+	switch logTarget {
+	case "":
+		logTarget = LogTargetFiles
+	case LogTargetFiles, LogTargetJournal:
+	default:
+		return "", fmt.Errorf("error: bad log target %q", cfg.LogTarget)
+	}
+	if err := ioutil.WriteFile(rktpath.LogTargetPath(dir), []byte(logTarget), 0644); err != nil {
+		return "", fmt.Errorf("error writing log target: %v", err)
+	}
+
+	// the container may be run by another process (cf. Run)
+	args := initArgs(cfg, *cuuid, logTarget)
+	if err := ioutil.WriteFile(rktpath.InitArgsPath(dir), []byte(strings.Join(args, "\x00")), 0644); err != nil {
+		return "", fmt.Errorf("error writing stage1 init arguments: %v", err)
+	}
+
 	log.Printf("Writing container manifest")
-	fn = rktpath.ContainerManifestPath(dir)
+	fn := rktpath.ContainerManifestPath(dir)
 	if err := ioutil.WriteFile(fn, cdoc, 0700); err != nil {
 		return "", fmt.Errorf("error writing container manifest: %v", err)
 	}
 	return dir, nil
 }
 
-// Run actually runs the container by exec()ing the stage1 init inside
-// the container filesystem.
-func Run(dir string, debug bool) {
+// initArgs returns the arguments of the stage1 run entrypoint running the
+// container set up with the given config
+func initArgs(cfg Config, cuuid types.UUID, logTarget string) []string {
+	args := []string{"--uuid=" + cuuid.String()}
+	if cfg.PrivateNet {
+		args = append(args, "--private-net", "--net-dir="+cfg.NetDir)
+	}
+	for _, p := range cfg.Ports {
+		args = append(args, "--port="+p.String())
+	}
+	args = append(args, "--log-target="+logTarget)
+	// the range may have been picked by Setup
+	if cfg.PrivateUsers != nil {
+		args = append(args, "--private-users="+cfg.PrivateUsers.String())
+	}
+	return args
+}
+
+// Run actually runs the container by exec()ing the run entrypoint of its
+// stage1 image inside the container filesystem. The container directory is
+// locked for as long as the container runs. Only the Debug setting of cfg
+// matters: the container runs the way it was set up by Setup, possibly in
+// another process.
+func Run(cfg Config, dir string) {
 	log.Printf("Pivoting to filesystem %s", dir)
 	if err := os.Chdir(dir); err != nil {
 		log.Fatalf("failed changing to dir: %v", err)
 	}
 
-	log.Printf("Execing %s", initPath)
-	args := []string{initPath}
-	if debug {
-		args = append(args, "debug")
+	l, err := lock.TryExclusiveLock(".")
+	if err != nil {
+		log.Fatalf("failed locking container dir: %v", err)
 	}
-	if err := syscall.Exec(initPath, args, os.Environ()); err != nil {
-		log.Fatalf("error execing init: %v", err)
+	if err := l.InheritOnExec(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	// from now on, the container is not about to run anymore (cf. rkt gc)
+	if err := ioutil.WriteFile(rktpath.StartedPath("."), nil, 0644); err != nil {
+		log.Fatalf("error recording container start: %v", err)
+	}
+
+	ep, err := Stage1Entrypoint(".", EntrypointRun)
+	if err != nil {
+		log.Fatalf("error finding stage1 run entrypoint: %v", err)
+	}
+	b, err := ioutil.ReadFile(rktpath.InitArgsPath("."))
+	if err != nil {
+		log.Fatalf("error reading stage1 init arguments: %v", err)
 	}
-}
 
-func untarRootfs(r io.Reader, dir string) error {
-	tr := tar.NewReader(r)
-	if err := os.MkdirAll(dir, 0776); err != nil {
-		return fmt.Errorf("error creating stage1 rootfs directory: %v", err)
+	log.Printf("Execing %s", ep[0])
+	args := ep
+	if cfg.Debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, strings.Split(string(b), "\x00")...)
+	if err := syscall.Exec(args[0], args, os.Environ()); err != nil {
+		log.Fatalf("error execing init: %v", err)
 	}
+}
 
-	if err := ptar.ExtractTar(tr, dir); err != nil {
-		return fmt.Errorf("error extracting rootfs: %v", err)
+// checkPorts verifies that the ports requested to be published on the host
+// are declared by the apps, in a way that can be forwarded
+func checkPorts(cfg Config, apps []*schema.AppManifest) error {
+	if len(cfg.Ports) == 0 {
+		return nil
+	}
+	if !cfg.PrivateNet {
+		return fmt.Errorf("error: publishing ports requires a private network")
+	}
+
+	hostPorts := make(map[string]types.ACName)
+	for _, pf := range cfg.Ports {
+		var port *types.Port
+		for _, am := range apps {
+			for i, p := range am.Ports {
+				if !p.Name.Equals(pf.Name) {
+					continue
+				}
+				if port != nil && (port.Protocol != p.Protocol || port.Port != p.Port) {
+					return fmt.Errorf("error: port %q declared as both %s/%d and %s/%d", pf.Name, port.Protocol, port.Port, p.Protocol, p.Port)
+				}
+				port = &am.Ports[i]
+			}
+		}
+		if port == nil {
+			return fmt.Errorf("error: unknown port %q", pf.Name)
+		}
+		switch port.Protocol {
+		case "tcp", "udp":
+		default:
+			return fmt.Errorf("error: port %q has protocol %q; only tcp and udp can be published", pf.Name, port.Protocol)
+		}
+		key := fmt.Sprintf("%s/%d", port.Protocol, pf.HostPort)
+		if n, ok := hostPorts[key]; ok {
+			return fmt.Errorf("error: host port %s requested for both %q and %q", key, n, pf.Name)
+		}
+		hostPorts[key] = pf.Name
 	}
 	return nil
 }
 
-// unpackRootfs unpacks a stage1 rootfs (compressed file, pointed to by rfs)
-// into dir, returning any error encountered
-func unpackRootfs(rfs string, dir string) error {
-	fh, err := os.Open(rfs)
+// setupPrivateUsers picks the uid range of the container, if not given, and
+// records it in the container directory dir
+func setupPrivateUsers(cfg Config, dir string) (*uid.Range, error) {
+	// hold off other containers picking a range until this one is recorded
+	l, err := lock.ExclusiveLock(cfg.ContainersDir)
 	if err != nil {
-		return fmt.Errorf("error opening stage1 rootfs: %v", err)
+		return nil, err
 	}
-	typ, err := aci.DetectFileType(fh)
-	if err != nil {
-		return fmt.Errorf("error detecting image type: %v", err)
+	defer l.Unlock()
+
+	r := cfg.PrivateUsers
+	if r.Count == 0 {
+		if r, err = pickUidRange(cfg.ContainersDir); err != nil {
+			return nil, err
+		}
 	}
-	if _, err := fh.Seek(0, 0); err != nil {
-		return fmt.Errorf("error seeking image: %v", err)
+	if err := ioutil.WriteFile(rktpath.PrivateUsersPath(dir), []byte(r.String()), 0644); err != nil {
+		return nil, err
 	}
-	var r io.Reader
-	switch typ {
-	case aci.TypeGzip:
-		r, err = gzip.NewReader(fh)
+	return r, nil
+}
+
+// pickUidRange returns a range of uid.DefaultCount uids that does not overlap
+// the ranges of the other containers in containersDir
+func pickUidRange(containersDir string) (*uid.Range, error) {
+	var used []uid.Range
+	dirs, err := ioutil.ReadDir(containersDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		b, err := ioutil.ReadFile(rktpath.PrivateUsersPath(filepath.Join(containersDir, d.Name())))
+		if os.IsNotExist(err) {
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("error reading gzip: %v", err)
+			return nil, err
+		}
+		r, err := uid.ParseRange(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("container %s: %v", d.Name(), err)
+		}
+		used = append(used, *r)
+	}
+
+	// leave the first range to the host
+	for base := uint64(uid.DefaultCount); base+uid.DefaultCount < 1<<32-1; base += uid.DefaultCount {
+		r := uid.Range{Base: uint32(base), Count: uid.DefaultCount}
+		free := true
+		for _, u := range used {
+			if r.Overlaps(u) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return &r, nil
 		}
-	case aci.TypeBzip2:
-		r = bzip2.NewReader(fh)
-	case aci.TypeXz:
-		r = aci.XzReader(fh)
-	case aci.TypeUnknown:
-		return fmt.Errorf("error: unknown image filetype")
-	default:
-		// should never happen
-		panic("no type returned from DetectFileType?")
 	}
+	return nil, fmt.Errorf("no free uid range")
+}
 
-	if err := untarRootfs(r, dir); err != nil {
-		return fmt.Errorf("error untarring rootfs")
+// shiftDir makes the directory p, created by stage0, owned by the root of
+// the container's user namespace
+func shiftDir(p string, r *uid.Range) error {
+	if r == nil {
+		return nil
 	}
-
-	return nil
+	return os.Chown(p, int(r.Base), int(r.Base))
 }
 
-// unpackBuiltinRootfs unpacks the included stage1 rootfs into dir
-func unpackBuiltinRootfs(dir string) error {
-	b, err := stage1_rootfs.Asset("s1rootfs.tar")
+// setupStage1Image extracts the stage1 image with the hash cfg.Stage1Image
+// from the store into the container directory dir: its rootfs becomes the
+// stage1 rootfs, while its app manifest, declaring the entrypoints of stage1,
+// and its hash are recorded alongside. A private copy of the image is kept
+// too, for the entrypoints other than run (cf. ImageEntrypoint).
+func setupStage1Image(cfg Config, dir string) error {
+	h, err := types.NewHash(cfg.Stage1Image)
+	if err != nil {
+		return fmt.Errorf("bad image hash %q: %v", cfg.Stage1Image, err)
+	}
+	tmp, err := extractImage(cfg, *h, dir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	b, err := ioutil.ReadFile(filepath.Join(tmp, "app"))
 	if err != nil {
-		return fmt.Errorf("error accessing rootfs asset: %v", err)
+		return fmt.Errorf("error reading app manifest: %v", err)
+	}
+	var am schema.AppManifest
+	if err := json.Unmarshal(b, &am); err != nil {
+		return fmt.Errorf("error unmarshaling app manifest: %v", err)
+	}
+	// the image must at least be able to run the container
+	if _, err := Entrypoint(&am, EntrypointRun); err != nil {
+		return fmt.Errorf("error: unusable stage1 image %s: %v", am.Name, err)
 	}
-	buf := bytes.NewBuffer(b)
 
-	if err = untarRootfs(buf, dir); err != nil {
-		return fmt.Errorf("error untarring rootfs")
+	s1 := rktpath.Stage1RootfsPath(dir)
+	if err := os.Rename(filepath.Join(tmp, "rootfs"), s1); err != nil {
+		return fmt.Errorf("error renaming rootfs: %v", err)
+	}
+	if err := shiftDir(s1, cfg.PrivateUsers); err != nil {
+		return fmt.Errorf("error shifting rootfs: %v", err)
+	}
+	if err := ioutil.WriteFile(rktpath.Stage1ManifestPath(dir), b, 0644); err != nil {
+		return fmt.Errorf("error writing app manifest: %v", err)
+	}
+	if err := ioutil.WriteFile(rktpath.Stage1IDPath(dir), []byte(h.String()), 0644); err != nil {
+		return fmt.Errorf("error writing image hash: %v", err)
 	}
 
+	// the copy is extracted anew, for the apps not to share its files;
+	// its ownership is not shifted, as only the host uses it
+	cp, err := extractImage(Config{Store: cfg.Store}, *h, dir)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(cp, rktpath.Stage1ImagePath(dir)); err != nil {
+		os.RemoveAll(cp)
+		return fmt.Errorf("error renaming stage1 image copy: %v", err)
+	}
 	return nil
 }
 
 // setupImage attempts to load the image by the given hash from the store,
 // verifies that the image matches the given hash and then extracts the image
-// into a directory in the given dir.
-// It returns the AppManifest that the image contains
-func setupImage(cfg Config, img string, h types.Hash, dir string) (*schema.AppManifest, error) {
-	log.Println("Loading image", img)
-
-	rs, err := cfg.Store.ReadStream(img)
+// into a directory in the given dir, named after the app.
+// It returns the AppManifest that the image contains and the name of the app
+// in the container.
+func setupImage(cfg Config, ac AppConfig, h types.Hash, dir string) (*schema.AppManifest, types.ACName, error) {
+	// The app name is only known once the manifest is read, so the image is
+	// first extracted to a temporary directory next to the final one
+	s2dir := rktpath.Stage2Path(dir)
+	if err := os.MkdirAll(s2dir, 0776); err != nil {
+		return nil, "", fmt.Errorf("error creating stage2 directory: %v", err)
+	}
+	if err := shiftDir(s2dir, cfg.PrivateUsers); err != nil {
+		return nil, "", fmt.Errorf("error shifting stage2 directory: %v", err)
+	}
+	tmp, err := extractImage(cfg, h, s2dir)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	// gone once renamed, unless setting it up fails
+	defer os.RemoveAll(tmp)
 
-	// Sanity check: provided image name matches image ID
-	b, err := ioutil.ReadAll(rs)
+	tmpdir := filepath.Join(tmp, "rootfs/tmp")
+	if _, err := os.Stat(tmpdir); os.IsNotExist(err) {
+		if err := os.MkdirAll(tmpdir, 0777); err != nil {
+			return nil, "", fmt.Errorf("error creating tmp directory: %v", err)
+		}
+		if err := shiftDir(tmpdir, cfg.PrivateUsers); err != nil {
+			return nil, "", fmt.Errorf("error shifting tmp directory: %v", err)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(tmp, "app"))
 	if err != nil {
-		return nil, fmt.Errorf("error reading tarball: %v", err)
+		return nil, "", fmt.Errorf("error reading app manifest: %v", err)
 	}
-	sum := sha256.Sum256(b)
-	if id := fmt.Sprintf("%x", sum); id != h.Val {
-		return nil, fmt.Errorf("image hash does not match expected")
+	var am schema.AppManifest
+	if err := json.Unmarshal(b, &am); err != nil {
+		return nil, "", fmt.Errorf("error unmarshaling app manifest: %v", err)
 	}
 
-	ad := rktpath.AppImagePath(dir, h)
-	err = os.MkdirAll(ad, 0776)
-	if err != nil {
-		return nil, fmt.Errorf("error creating image directory: %v", err)
+	name := am.Name
+	if ac.Name != "" {
+		name = ac.Name
+	}
+	ad := rktpath.AppImagePath(dir, name)
+	if _, err := os.Stat(ad); err == nil {
+		return nil, "", fmt.Errorf("error: multiple apps with name %s", name)
 	}
-	if err := ptar.ExtractTar(tar.NewReader(bytes.NewReader(b)), ad); err != nil {
-		return nil, fmt.Errorf("error extracting ACI: %v", err)
+	if err := os.Rename(tmp, ad); err != nil {
+		return nil, "", fmt.Errorf("error renaming image directory: %v", err)
 	}
+	return &am, name, nil
+}
 
-	err = os.MkdirAll(filepath.Join(ad, "rootfs/tmp"), 0777)
+// extractImage loads the image by the given hash from the store, extracts it
+// into a new temporary directory in parent, whose path is returned, and
+// verifies that the image matches the hash. Nothing is left in parent if
+// either fails.
+func extractImage(cfg Config, h types.Hash, parent string) (string, error) {
+	img := h.String()
+	log.Println("Loading image", img)
+
+	rs, err := cfg.Store.ReadStream(img)
 	if err != nil {
-		return nil, fmt.Errorf("error creating tmp directory: %v", err)
+		return "", err
 	}
+	defer rs.Close()
 
-	mpath := rktpath.AppManifestPath(dir, h)
-	f, err := os.Open(mpath)
+	tmp, err := ioutil.TempDir(parent, ".image-")
 	if err != nil {
-		return nil, fmt.Errorf("error opening app manifest: %v", err)
+		return "", fmt.Errorf("error creating image directory: %v", err)
 	}
-	b, err = ioutil.ReadAll(f)
-	if err != nil {
-		return nil, fmt.Errorf("error reading app manifest: %v", err)
+	if err := extractImageTo(cfg, h, rs, tmp); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
 	}
-	var am schema.AppManifest
-	if err := json.Unmarshal(b, &am); err != nil {
-		return nil, fmt.Errorf("error unmarshaling app manifest: %v", err)
+	return tmp, nil
+}
+
+// extractImageTo extracts the image read from r into dir, hashing it on the
+// way to verify it matches h
+func extractImageTo(cfg Config, h types.Hash, r io.Reader, dir string) error {
+	if err := os.Chmod(dir, 0755); err != nil {
+		return fmt.Errorf("error setting image directory permissions: %v", err)
+	}
+	if err := shiftDir(dir, cfg.PrivateUsers); err != nil {
+		return fmt.Errorf("error shifting image directory: %v", err)
+	}
+	hash := sha256.New()
+	tr := io.TeeReader(r, hash)
+	if err := ptar.ExtractTarShifted(tar.NewReader(tr), dir, cfg.PrivateUsers); err != nil {
+		return fmt.Errorf("error extracting ACI: %v", err)
+	}
+	// the padding after the end of the archive is hashed too
+	if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+		return fmt.Errorf("error reading tarball: %v", err)
 	}
-	return &am, nil
+	// Sanity check: provided image name matches image ID
+	if id := fmt.Sprintf("%x", hash.Sum(nil)); id != h.Val {
+		return fmt.Errorf("image hash does not match expected")
+	}
+	return nil
 }
@@ -0,0 +1,165 @@
+package stage0
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/cas"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/portfwd"
+	"github.com/coreos/rocket/pkg/uid"
+)
+
+func TestInitArgs(t *testing.T) {
+	cuuid, err := types.NewUUID("6733c088-a507-4694-aabf-edbe4fc5266f")
+	if err != nil {
+		t.Fatalf("error parsing UUID: %v", err)
+	}
+	port, err := portfwd.Parse("http:8080")
+	if err != nil {
+		t.Fatalf("error parsing port: %v", err)
+	}
+	u := "--uuid=6733c088-a507-4694-aabf-edbe4fc5266f"
+
+	tests := []struct {
+		cfg       Config
+		logTarget string
+		args      []string
+	}{
+		{
+			Config{},
+			LogTargetFiles,
+			[]string{u, "--log-target=files"},
+		},
+		{
+			Config{PrivateNet: true, NetDir: "/var/lib/rkt/net", Ports: []portfwd.Request{*port}},
+			LogTargetJournal,
+			[]string{u, "--private-net", "--net-dir=/var/lib/rkt/net", "--port=http:8080", "--log-target=journal"},
+		},
+		{
+			Config{PrivateUsers: &uid.Range{Base: 100000, Count: 65536}},
+			LogTargetFiles,
+			[]string{u, "--log-target=files", "--private-users=100000:65536"},
+		},
+	}
+	for i, tt := range tests {
+		if args := initArgs(tt.cfg, *cuuid, tt.logTarget); !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("#%d: got %q, want %q", i, args, tt.args)
+		}
+	}
+}
+
+// stage2Entries returns the names of the entries of the stage2 directory of
+// the container in dir
+func stage2Entries(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(rktpath.Stage2Path(dir))
+	if err != nil {
+		t.Fatalf("error reading stage2 directory: %v", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestSetupImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-stage0")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	key, err := ds.WriteACI(types.NewHashSHA256([]byte("image")).String(), testStage1Image(t))
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	h, err := types.NewHash(key)
+	if err != nil {
+		t.Fatalf("bad image hash %q: %v", key, err)
+	}
+	cfg := Config{Store: ds}
+	cdir, err := ioutil.TempDir(dir, "container")
+	if err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+
+	if _, name, err := setupImage(cfg, AppConfig{}, *h, cdir); err != nil || name != "example.com/stage1" {
+		t.Fatalf("unexpected result setting up image: %q, %v", name, err)
+	}
+	if _, err := os.Stat(rktpath.AppImagePath(cdir, "example.com/stage1")); err != nil {
+		t.Errorf("image not set up: %v", err)
+	}
+
+	// nothing is left behind by the images failing to be set up
+	if _, _, err := setupImage(cfg, AppConfig{}, *h, cdir); err == nil {
+		t.Errorf("expected error setting up an app of the same name")
+	}
+	if got := stage2Entries(t, cdir); len(got) != 1 {
+		t.Errorf("got stage2 entries %q, want only the app", got)
+	}
+	bad := *h
+	bad.Val = strings.Repeat("0", len(h.Val))
+	if _, _, err := setupImage(cfg, AppConfig{}, bad, cdir); err == nil {
+		t.Errorf("expected error setting up an image not in the store")
+	}
+	if got := stage2Entries(t, cdir); len(got) != 1 {
+		t.Errorf("got stage2 entries %q, want only the app", got)
+	}
+
+	// the image read does not match its hash
+	tmp, err := ioutil.TempDir(dir, "image")
+	if err != nil {
+		t.Fatalf("error creating image dir: %v", err)
+	}
+	if err := extractImageTo(cfg, bad, testStage1Image(t), tmp); err == nil {
+		t.Errorf("expected error extracting image not matching its hash")
+	}
+	if err := extractImageTo(cfg, *h, testStage1Image(t), tmp); err != nil {
+		t.Errorf("unexpected error extracting image: %v", err)
+	}
+}
+
+func TestExtractImageCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-stage0")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+
+	// an image failing to extract
+	img := testStage1Image(t)
+	b := img.Bytes()
+	img.Truncate(len(b) - 1024) // the end of the archive
+	tw := tar.NewWriter(img)
+	if err := tw.WriteHeader(&tar.Header{Name: "rootfs/bad", Typeflag: tar.TypeSymlink, Linkname: "../../../../etc/passwd"}); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	key, err := ds.WriteACI(types.NewHashSHA256([]byte("image")).String(), img)
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	h, err := types.NewHash(key)
+	if err != nil {
+		t.Fatalf("bad image hash %q: %v", key, err)
+	}
+	parent, err := ioutil.TempDir(dir, "parent")
+	if err != nil {
+		t.Fatalf("error creating parent dir: %v", err)
+	}
+	if _, err := extractImage(Config{Store: ds}, *h, parent); err == nil {
+		t.Fatalf("expected error extracting image")
+	}
+	if fis, _ := ioutil.ReadDir(parent); len(fis) != 0 {
+		t.Errorf("image directory left behind: %v", fis[0].Name())
+	}
+}
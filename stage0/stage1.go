@@ -0,0 +1,118 @@
+package stage0
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// The entrypoints of stage1, as described in Documentation/stage1-interface.md.
+// Only EntrypointRun is mandatory.
+const (
+	EntrypointRun   = "run"   // run the container
+	EntrypointEnter = "enter" // run a command in a running container
+	EntrypointStop  = "stop"  // stop a running container
+	EntrypointKill  = "kill"  // signal the apps of a running container
+	EntrypointGC    = "gc"    // clean up after an exited container
+)
+
+const (
+	// Stage1InterfaceVersion is the version of the stage1 entrypoints this
+	// stage0 implements
+	Stage1InterfaceVersion = 1
+
+	// entrypointPrefix prefixes the annotations of the stage1 image app
+	// manifest declaring the entrypoints: the one named after an entrypoint
+	// holds its command line, relative to the stage1 rootfs, and the one
+	// with the "-version" suffix the version of the interface it implements
+	entrypointPrefix = "coreos.com/rkt/stage1/"
+)
+
+// ErrNoEntrypoint is returned for entrypoints a stage1 image does not declare
+var ErrNoEntrypoint = errors.New("no such entrypoint")
+
+// Entrypoint returns the command line of the given entrypoint of the stage1
+// image with the app manifest am, relative to its rootfs. It fails if the
+// entrypoint implements another version of the interface than this stage0.
+func Entrypoint(am *schema.AppManifest, name string) ([]string, error) {
+	cmd := strings.Fields(am.Annotations[types.ACName(entrypointPrefix+name)])
+	if len(cmd) == 0 {
+		return nil, ErrNoEntrypoint
+	}
+	if !filepath.IsAbs(cmd[0]) {
+		return nil, fmt.Errorf("%s entrypoint %q is not an absolute path", name, cmd[0])
+	}
+	v := am.Annotations[types.ACName(entrypointPrefix+name+"-version")]
+	if n, err := strconv.Atoi(v); err != nil || n != Stage1InterfaceVersion {
+		return nil, fmt.Errorf("%s entrypoint implements version %q of the stage1 interface, rkt implements version %d", name, v, Stage1InterfaceVersion)
+	}
+	return cmd, nil
+}
+
+// Stage1Entrypoint returns the command line of the given entrypoint of the
+// stage1 image of the container set up in dir (cf. Entrypoint), with the
+// absolute path of the executable. Entrypoints run in dir.
+//
+// The stage1 rootfs of the container is the root of the container, which the
+// apps can write to: once the container has run, entrypoints are to be run
+// from the private copy of the image instead (cf. ImageEntrypoint).
+func Stage1Entrypoint(dir, name string) ([]string, error) {
+	am, err := Stage1Manifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return rootfsEntrypoint(am, rktpath.Stage1RootfsPath(dir), name)
+}
+
+// ImageEntrypoint returns the command line of the given entrypoint of the
+// stage1 image extracted in imgDir (e.g. rktpath.Stage1ImagePath of the
+// container directory), with the absolute path of the executable. Entrypoints
+// run in the container directory.
+func ImageEntrypoint(imgDir, name string) ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(imgDir, "app"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading stage1 app manifest: %v", err)
+	}
+	am := &schema.AppManifest{}
+	if err := json.Unmarshal(b, am); err != nil {
+		return nil, fmt.Errorf("error unmarshaling stage1 app manifest: %v", err)
+	}
+	return rootfsEntrypoint(am, filepath.Join(imgDir, "rootfs"), name)
+}
+
+// rootfsEntrypoint returns the command line of the given entrypoint of the
+// stage1 image with the app manifest am, with the executable in rootfs
+func rootfsEntrypoint(am *schema.AppManifest, rootfs, name string) ([]string, error) {
+	cmd, err := Entrypoint(am, name)
+	if err != nil {
+		return nil, err
+	}
+	s1, err := filepath.Abs(rootfs)
+	if err != nil {
+		return nil, err
+	}
+	cmd[0] = filepath.Join(s1, cmd[0])
+	return cmd, nil
+}
+
+// Stage1Manifest returns the app manifest of the stage1 image of the
+// container set up in dir
+func Stage1Manifest(dir string) (*schema.AppManifest, error) {
+	b, err := ioutil.ReadFile(rktpath.Stage1ManifestPath(dir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading stage1 app manifest: %v", err)
+	}
+	am := &schema.AppManifest{}
+	if err := json.Unmarshal(b, am); err != nil {
+		return nil, fmt.Errorf("error unmarshaling stage1 app manifest: %v", err)
+	}
+	return am, nil
+}
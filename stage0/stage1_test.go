@@ -0,0 +1,164 @@
+package stage0
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/cas"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+func TestEntrypoint(t *testing.T) {
+	tests := []struct {
+		annotations map[string]string
+		cmd         []string
+		err         error // expected error, if not any
+	}{
+		{
+			map[string]string{"run": "/init run --flag", "run-version": "1"},
+			[]string{"/init", "run", "--flag"},
+			nil,
+		},
+		{
+			map[string]string{"stop": "/init stop", "stop-version": "1"},
+			nil,
+			ErrNoEntrypoint,
+		},
+		{
+			map[string]string{"run": "  ", "run-version": "1"},
+			nil,
+			ErrNoEntrypoint,
+		},
+		{
+			map[string]string{"run": "init run", "run-version": "1"},
+			nil,
+			nil,
+		},
+		{
+			map[string]string{"run": "/init run"},
+			nil,
+			nil,
+		},
+		{
+			map[string]string{"run": "/init run", "run-version": "2"},
+			nil,
+			nil,
+		},
+		{
+			map[string]string{"run": "/init run", "run-version": "one"},
+			nil,
+			nil,
+		},
+	}
+	for i, tt := range tests {
+		am := &schema.AppManifest{Annotations: make(types.Annotations)}
+		for k, v := range tt.annotations {
+			am.Annotations[types.ACName(entrypointPrefix+k)] = v
+		}
+		cmd, err := Entrypoint(am, EntrypointRun)
+		if tt.cmd == nil {
+			if err == nil {
+				t.Errorf("#%d: expected error, got %q", i, cmd)
+			} else if tt.err != nil && err != tt.err {
+				t.Errorf("#%d: got error %v, want %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(cmd, tt.cmd) {
+			t.Errorf("#%d: got %q, want %q", i, cmd, tt.cmd)
+		}
+	}
+}
+
+// testStage1Image returns a stage1 image declaring a run entrypoint
+func testStage1Image(t *testing.T) *bytes.Buffer {
+	files := map[string]string{
+		"app": `{"acKind": "AppManifest", "acVersion": "0.1.0", "name": "example.com/stage1", "os": "linux", "arch": "amd64", "exec": ["/init", "run"],
+			"annotations": {"coreos.com/rkt/stage1/run": "/init run", "coreos.com/rkt/stage1/run-version": "1"}}`,
+		"rootfs/init": "#!/bin/sh\n",
+	}
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	if err := tw.WriteHeader(&tar.Header{Name: "rootfs", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	for _, name := range []string{"app", "rootfs/init"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(files[name]))}); err != nil {
+			t.Fatalf("error writing image: %v", err)
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			t.Fatalf("error writing image: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	return &b
+}
+
+func TestSetupStage1Image(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-stage0")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	ds := cas.NewStore(dir)
+	key, err := ds.WriteACI(types.NewHashSHA256([]byte("stage1")).String(), testStage1Image(t))
+	if err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	cdir := filepath.Join(dir, "container")
+	if err := os.Mkdir(cdir, 0755); err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+
+	if err := setupStage1Image(Config{Store: ds, Stage1Image: key}, cdir); err != nil {
+		t.Fatalf("unexpected error setting up stage1: %v", err)
+	}
+	if b, err := ioutil.ReadFile(rktpath.Stage1IDPath(cdir)); err != nil || string(b) != key {
+		t.Errorf("stage1 image hash not recorded as %s: %q, %v", key, b, err)
+	}
+	if _, err := Stage1Entrypoint(cdir, EntrypointRun); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the private copy of the image does not share the files of the
+	// stage1 rootfs
+	s1 := rktpath.Stage1ImagePath(cdir)
+	cmd, err := ImageEntrypoint(s1, EntrypointRun)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{filepath.Join(s1, "rootfs/init"), "run"}; !reflect.DeepEqual(cmd, want) {
+		t.Errorf("got %q, want %q", cmd, want)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rktpath.Stage1RootfsPath(cdir), "init"), []byte("written by an app"), 0755); err != nil {
+		t.Fatalf("error writing to stage1 rootfs: %v", err)
+	}
+	if b, err := ioutil.ReadFile(cmd[0]); err != nil || string(b) != "#!/bin/sh\n" {
+		t.Errorf("entrypoint of the private copy altered: %q, %v", b, err)
+	}
+	if _, err := ImageEntrypoint(s1, EntrypointStop); err != ErrNoEntrypoint {
+		t.Errorf("expected ErrNoEntrypoint, got %v", err)
+	}
+
+	// the image must be in the store
+	cdir = filepath.Join(dir, "other")
+	if err := os.Mkdir(cdir, 0755); err != nil {
+		t.Fatalf("error creating container dir: %v", err)
+	}
+	if err := setupStage1Image(Config{Store: ds, Stage1Image: "sha256-0123"}, cdir); err == nil {
+		t.Errorf("expected error setting up stage1 image not in the store")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/journal"
+)
+
+const (
+	// stage1Init is the path of the init in the stage1 rootfs, which the
+	// systemd of stage1 runs the commands of the apps through
+	stage1Init = "/init"
+	// appPriority is the syslog priority of the output of the apps (info)
+	appPriority = 6
+	// maxLogLine is the length output lines are split at
+	maxLogLine = 16 * 1024
+)
+
+// journalSocket is where the output of the apps is forwarded to
+var journalSocket = journal.SocketPath
+
+// appExec returns the ExecStart (or handler) line of the systemd unit running
+// args in the rootfs of the given app, as uid and gid, through appEntry
+func (c *Container) appExec(appName types.ACName, uid, gid int, args []string) string {
+	argv := []string{
+		stage1Init, "app",
+		"--uuid=" + c.Manifest.UUID.String(),
+		"--app=" + appName.String(),
+		"--uid=" + strconv.Itoa(uid),
+		"--gid=" + strconv.Itoa(gid),
+		"--",
+	}
+	return quoteExec(append(argv, args...))
+}
+
+// appEntry runs a command of an app in its rootfs, in place of the systemd
+// unit setting up the rootfs and user, so that the output of the command is
+// forwarded to the journal by logEntry, with the container UUID and app name
+// attached. The command replaces the init, keeping its PID, so that systemd
+// sees it as the main process of the unit.
+func appEntry(args []string) int {
+	var (
+		app      string
+		uid, gid int
+	)
+	fs := flag.NewFlagSet("app", flag.ExitOnError)
+	fs.StringVar(&uuid, "uuid", "", "UUID of the container")
+	fs.StringVar(&app, "app", "", "App to run the command as")
+	fs.IntVar(&uid, "uid", 0, "User to run the command as")
+	fs.IntVar(&gid, "gid", 0, "Group to run the command as")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No command to run")
+		return 1
+	}
+	appName, err := types.NewACName(app)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid app name %q: %v\n", app, err)
+		return 1
+	}
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create output pipe: %v\n", err)
+		return 1
+	}
+	logger := exec.Command(stage1Init, "log", "--uuid="+uuid, "--app="+app)
+	logger.Stdin = pr
+	logger.Stdout = os.Stdout
+	logger.Stderr = os.Stderr
+	if err := logger.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start logger: %v\n", err)
+		return 1
+	}
+	pr.Close()
+
+	// the output of the command goes to the logger from now on; the
+	// descriptors duplicated are not closed on exec
+	for _, fd := range []int{syscall.Stdout, syscall.Stderr} {
+		if err := syscall.Dup2(int(pw.Fd()), fd); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to redirect output: %v\n", err)
+			return 1
+		}
+	}
+	pw.Close()
+
+	if err := syscall.Chroot(path.RelAppRootfsPath(*appName)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to enter app rootfs: %v\n", err)
+		return 1
+	}
+	if err := os.Chdir("/"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to enter app rootfs: %v\n", err)
+		return 1
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set groups: %v\n", err)
+		return 1
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set group: %v\n", err)
+		return 1
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set user: %v\n", err)
+		return 1
+	}
+	cmd := fs.Args()
+	if err := syscall.Exec(cmd[0], cmd, os.Environ()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to execute %q: %v\n", cmd[0], err)
+	}
+	return 1
+}
+
+// logEntry forwards the output of a command of an app, read from its stdin,
+// to the journal, one entry per line, with the container UUID and app name
+// attached. The lines are copied to the console too, as the output of the
+// other units of stage1 is. It exits once the output is closed: the signals
+// stopping the unit are ignored, for the last lines not to be lost.
+func logEntry(args []string) int {
+	var app string
+	fs := flag.NewFlagSet("log", flag.ExitOnError)
+	fs.StringVar(&uuid, "uuid", "", "UUID of the container")
+	fs.StringVar(&app, "app", "", "App whose output is logged")
+	fs.Parse(args)
+
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	l, err := journal.Dial(journalSocket, map[string]string{
+		"CONTAINER_UUID":    uuid,
+		"APP_NAME":          app,
+		"SYSLOG_IDENTIFIER": app,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log app output: %v\n", err)
+		// the output of the unit goes to the journal, without the
+		// fields of the app
+		io.Copy(os.Stdout, os.Stdin)
+		return 1
+	}
+	defer l.Close()
+	var console io.Writer
+	if f, err := os.OpenFile("/dev/console", os.O_WRONLY, 0); err == nil {
+		defer f.Close()
+		console = f
+	}
+	if err := forwardLog(l, os.Stdin, console, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log app output: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// forwardLog sends the lines read from r to l, and copies them to console if
+// it is not nil. Lines longer than maxLogLine are split. Should l fail, the
+// rest of the output is copied to fallback, for the command not to block.
+func forwardLog(l *journal.Logger, r io.Reader, console, fallback io.Writer) error {
+	br := bufio.NewReaderSize(r, maxLogLine)
+	for {
+		line, _, err := br.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := l.Log(string(line), appPriority); err != nil {
+			fallback.Write(append(line, '\n'))
+			io.Copy(fallback, br)
+			return err
+		}
+		if console != nil {
+			console.Write(append(line, '\n'))
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/pkg/journal"
+)
+
+func TestAppExec(t *testing.T) {
+	am := testAppManifest("web")
+	am.Exec = []string{"/web", "--port", "80"}
+	c := newTestContainer(t, []schema.App{{Name: "web"}}, []*schema.AppManifest{am})
+	defer os.RemoveAll(c.Root)
+
+	if err := c.ContainerToSystemd(); err != nil {
+		t.Fatalf("unexpected error generating units: %v", err)
+	}
+	web := readUnit(t, ServiceFilePath(c.Root, "web"))
+	execs := unitValues(web, "Service", "ExecStart")
+	if len(execs) != 1 {
+		t.Fatalf("expected one ExecStart, got %v", execs)
+	}
+	argv, err := splitWords(execs[0])
+	if err != nil {
+		t.Fatalf("bad ExecStart %q: %v", execs[0], err)
+	}
+	want := []string{
+		stage1Init, "app",
+		"--uuid=6733c088-a507-4694-aabf-edbe4fc5266f", "--app=web", "--uid=0", "--gid=0",
+		"--", "/web", "--port", "80",
+	}
+	if strings.Join(argv, " ") != strings.Join(want, " ") {
+		t.Errorf("got ExecStart %q, want %q", argv, want)
+	}
+	// the rootfs and user are set up by the init
+	for _, opt := range []string{"RootDirectory", "User", "Group"} {
+		if got := unitValues(web, "Service", opt); len(got) != 0 {
+			t.Errorf("unexpected %s=%v", opt, got)
+		}
+	}
+}
+
+func TestForwardLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-stage1")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "journal")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	l, err := journal.Dial(sock, map[string]string{"APP_NAME": "web"})
+	if err != nil {
+		t.Fatalf("error dialing journal: %v", err)
+	}
+	defer l.Close()
+
+	long := strings.Repeat("x", maxLogLine+1)
+	var console, fallback bytes.Buffer
+	if err := forwardLog(l, strings.NewReader("hello\n\n"+long+"\nbye"), &console, &fallback); err != nil {
+		t.Fatalf("unexpected error forwarding: %v", err)
+	}
+	msgs := []string{"hello", "", long[:maxLogLine], "x", "bye"}
+	b := make([]byte, 2*maxLogLine)
+	for i, msg := range msgs {
+		n, err := ln.Read(b)
+		if err != nil {
+			t.Fatalf("error reading entry: %v", err)
+		}
+		want := "APP_NAME=web\nMESSAGE=" + msg + "\nPRIORITY=6\n"
+		if string(b[:n]) != want {
+			t.Errorf("#%d: got entry %q, want %q", i, b[:n], want)
+		}
+	}
+	if want := strings.Join(msgs, "\n") + "\n"; console.String() != want {
+		t.Errorf("got console output %q, want %q", console.String(), want)
+	}
+	if fallback.Len() != 0 {
+		t.Errorf("unexpected fallback output %q", fallback.String())
+	}
+
+	// the output goes on once the journal is gone
+	ln.Close()
+	os.Remove(sock)
+	console.Reset()
+	if err := forwardLog(l, strings.NewReader("hello\nbye\n"), &console, &fallback); err == nil {
+		t.Errorf("expected error forwarding to closed journal")
+	}
+	if fallback.String() != "hello\nbye\n" {
+		t.Errorf("got fallback output %q, want %q", fallback.String(), "hello\nbye\n")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// appCfg is how an app runs, as passed by the init to its helper in app mode
+type appCfg struct {
+	Rootfs   string
+	Exec     []string
+	Env      []string
+	Uid      int
+	Gid      int
+	Handlers map[string][]string // event handlers, by event
+}
+
+// startupFd is the file descriptor on which the helper of an app tells the
+// init that the app is running, by writing a byte to it once the app and its
+// post-start handler have started. It is closed without a byte written if the
+// app does not start.
+const startupFd = 3
+
+// runApp runs an app in its rootfs, along with its event handlers, and exits
+// the way the app exited
+func runApp() int {
+	startup := os.NewFile(startupFd, "startup")
+	syscall.CloseOnExec(startupFd)
+
+	var cfg appCfg
+	if err := json.Unmarshal([]byte(appConfig), &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse app config: %v\n", err)
+		return 1
+	}
+	if len(cfg.Exec) == 0 {
+		fmt.Fprintln(os.Stderr, "App has nothing to execute")
+		return 1
+	}
+	if err := pivotRoot(cfg.Rootfs); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to enter app rootfs: %v\n", err)
+		return 1
+	}
+	return cfg.run(startup)
+}
+
+// run runs the app in the current root, telling the init on startup once it
+// is running, and returns the exit status of the app
+func (cfg *appCfg) run(startup *os.File) int {
+	defer startup.Close()
+
+	// signals are caught before the app starts, so that they are not
+	// missed while it does
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+
+	if err := cfg.runHandler("pre-start"); err != nil {
+		fmt.Fprintf(os.Stderr, "pre-start handler failed: %v\n", err)
+		return handlerStatus(err)
+	}
+
+	cmd := cfg.command(cfg.Exec)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start app: %v\n", err)
+		return 1
+	}
+	if err := cfg.runHandler("post-start"); err != nil {
+		fmt.Fprintf(os.Stderr, "post-start handler failed: %v\n", err)
+	}
+	if _, err := startup.Write([]byte{1}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to report app startup: %v\n", err)
+	}
+	startup.Close()
+
+	waitc := make(chan error, 1)
+	go func() { waitc <- cmd.Wait() }()
+	var err error
+wait:
+	for {
+		select {
+		case sig := <-sigc:
+			if err := cfg.runHandler("pre-stop"); err != nil {
+				fmt.Fprintf(os.Stderr, "pre-stop handler failed: %v\n", err)
+			}
+			cmd.Process.Signal(sig)
+		case err = <-waitc:
+			break wait
+		}
+	}
+
+	if err := cfg.runHandler("post-stop"); err != nil {
+		fmt.Fprintf(os.Stderr, "post-stop handler failed: %v\n", err)
+	}
+
+	if err == nil {
+		return 0
+	}
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Failed to wait for app: %v\n", err)
+		return 1
+	}
+	ws := ee.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		// the init sees the helper die of the signal the app died of
+		signal.Reset(ws.Signal())
+		syscall.Kill(os.Getpid(), ws.Signal())
+		// the signal is delivered asynchronously; should it not kill
+		// the helper, the exit status is the one of a shell
+		time.Sleep(time.Second)
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// command returns the command running args as the app, in its environment
+func (cfg *appCfg) command(args []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = "/"
+	cmd.Env = cfg.Env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{
+			Uid: uint32(cfg.Uid),
+			Gid: uint32(cfg.Gid),
+		},
+	}
+	return cmd
+}
+
+// runHandler runs the handler of the app for the given event, if any, and
+// waits for it
+func (cfg *appCfg) runHandler(event string) error {
+	h := cfg.Handlers[event]
+	if len(h) == 0 {
+		return nil
+	}
+	return cfg.command(h).Run()
+}
+
+// handlerStatus returns the status to exit with after a handler failed
+func handlerStatus(err error) int {
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws := ee.Sys().(syscall.WaitStatus); ws.Exited() && ws.ExitStatus() != 0 {
+			return ws.ExitStatus()
+		}
+	}
+	return 1
+}
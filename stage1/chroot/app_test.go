@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestAppHelper is not a test: it runs an app the way the helper of the init
+// does, when started by runAppHelper
+func TestAppHelper(t *testing.T) {
+	b := os.Getenv("RKT_CHROOT_TEST_APP")
+	if b == "" {
+		return
+	}
+	var cfg appCfg
+	if err := json.Unmarshal([]byte(b), &cfg); err != nil {
+		os.Exit(100)
+	}
+	os.Exit(cfg.run(os.NewFile(startupFd, "startup")))
+}
+
+// runAppHelper starts the helper of an app running the given commands, and
+// returns it along with the read end of its startupFd
+func runAppHelper(t *testing.T, args []string, handlers map[string][]string) (*exec.Cmd, *os.File) {
+	cfg := appCfg{
+		Exec:     args,
+		Env:      []string{"PATH=/usr/bin:/bin"},
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+		Handlers: handlers,
+	}
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("error encoding app config: %v", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("error creating pipe: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAppHelper$")
+	cmd.Env = append(os.Environ(), "RKT_CHROOT_TEST_APP="+string(b))
+	cmd.ExtraFiles = []*os.File{w}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		t.Fatalf("error starting app helper: %v", err)
+	}
+	return cmd, r
+}
+
+// waitAppHelper waits for the helper of an app and returns its exit status,
+// as the init sees it
+func waitAppHelper(t *testing.T, cmd *exec.Cmd) int {
+	cmd.Wait()
+	return exitStatus(cmd.ProcessState.Sys().(syscall.WaitStatus))
+}
+
+// running reports whether the helper reported the app running on startup
+func running(startup *os.File) bool {
+	defer startup.Close()
+	n, _ := startup.Read(make([]byte, 1))
+	return n == 1
+}
+
+func TestRunApp(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("running apps with their credentials requires root")
+	}
+	dir, err := ioutil.TempDir("", "rkt-chroot")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	touch := func(name string) []string {
+		return []string{"/bin/sh", "-c", "touch " + filepath.Join(dir, name)}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// exit statuses go through the helper
+	cmd, startup := runAppHelper(t, []string{"/bin/sh", "-c", "exit 3"}, map[string][]string{
+		"post-start": touch("post-start"),
+		"post-stop":  touch("post-stop"),
+	})
+	if !running(startup) {
+		t.Errorf("app not reported running")
+	}
+	if status := waitAppHelper(t, cmd); status != 3 {
+		t.Errorf("got exit status %d, want 3", status)
+	}
+	if !exists("post-start") || !exists("post-stop") {
+		t.Errorf("post-start and post-stop handlers not run")
+	}
+
+	// so do the signals apps die of
+	cmd, startup = runAppHelper(t, []string{"/bin/sh", "-c", "kill -KILL $$"}, nil)
+	running(startup)
+	if status := waitAppHelper(t, cmd); status != int(syscall.SIGKILL) {
+		t.Errorf("got exit status %d, want %d", status, syscall.SIGKILL)
+	}
+
+	// the signals the helper gets are passed on to the app
+	cmd, startup = runAppHelper(t, []string{"/bin/sleep", "10"}, map[string][]string{
+		"pre-stop": touch("pre-stop"),
+	})
+	if !running(startup) {
+		t.Fatalf("app not reported running")
+	}
+	start := time.Now()
+	cmd.Process.Signal(syscall.SIGTERM)
+	if status := waitAppHelper(t, cmd); status != int(syscall.SIGTERM) {
+		t.Errorf("got exit status %d, want %d", status, syscall.SIGTERM)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("app not stopped by SIGTERM")
+	}
+	if !exists("pre-stop") {
+		t.Errorf("pre-stop handler not run")
+	}
+
+	// apps do not run if their pre-start handler fails
+	cmd, startup = runAppHelper(t, touch("app"), map[string][]string{
+		"pre-start": {"/bin/sh", "-c", "exit 2"},
+	})
+	if running(startup) {
+		t.Errorf("app reported running after failed pre-start handler")
+	}
+	if status := waitAppHelper(t, cmd); status != 2 {
+		t.Errorf("got exit status %d, want 2", status)
+	}
+	if exists("app") {
+		t.Errorf("app run after failed pre-start handler")
+	}
+}
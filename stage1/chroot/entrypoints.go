@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/coreos/rocket/stage1/common"
+)
+
+// stopEntry asks the init of the running container to stop the apps, which
+// it does as when the main app exits
+func stopEntry(args []string) int {
+	common.EntryFlags("stop", &debug, &uuid).Parse(args)
+
+	c, err := common.LoadEntryContainer(uuid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	ipid, err := common.InitPid(c.Root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := syscall.Kill(ipid, syscall.SIGTERM); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop apps: %v\n", err)
+		return 1
+	}
+	return 0
+}
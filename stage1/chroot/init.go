@@ -0,0 +1,183 @@
+package main
+
+// this implements /init of stage1/chroot: the apps run in chroots of their
+// own, in namespaces set up with clone(2), supervised by a Go init instead of
+// systemd. It needs neither systemd nor systemd-nspawn on the host.
+//
+// It provides the run, enter and stop entrypoints of the stage1 interface
+// (cf. Documentation/stage1-interface.md) as subcommands. The run entrypoint
+// runs in three modes, re-executing itself for the last two:
+//  - run (the default): starts the init of the container in new mount, PID,
+//    UTS and IPC namespaces, and waits for it
+//  - pid1: the init of the container; it sets up the mounts of the container,
+//    starts the apps and reaps them, restarting them according to their
+//    restart policies
+//  - app: runs an app in its rootfs, in a mount namespace of its own, along
+//    with its event handlers
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/common"
+)
+
+const (
+	modeRun  = "run"
+	modePid1 = "pid1"
+	modeApp  = "app"
+
+	// selfExe is what the init re-executes; it is available in the
+	// container as well, where /proc is mounted
+	selfExe = "/proc/self/exe"
+)
+
+var (
+	runFlags = flag.NewFlagSet("run", flag.ExitOnError)
+
+	debug        bool
+	uuid         string
+	privateNet   bool
+	netDir       string
+	ports        stringList
+	privateUsers string
+	logTarget    string
+
+	mode      string
+	appConfig string
+)
+
+func init() {
+	runFlags.BoolVar(&debug, "debug", false, "Run in debug mode")
+	runFlags.StringVar(&uuid, "uuid", "", "UUID of the container")
+	runFlags.BoolVar(&privateNet, "private-net", false, "Setup private network (unsupported)")
+	runFlags.StringVar(&netDir, "net-dir", "", "Directory holding network state (unsupported)")
+	runFlags.Var(&ports, "port", "Publish the named app port on the given host port (unsupported)")
+	runFlags.StringVar(&privateUsers, "private-users", "", "Run in a user namespace mapped to the given uid range (unsupported)")
+	runFlags.StringVar(&logTarget, "log-target", "files", "Ignored: the output of the apps is the one of the init")
+	runFlags.StringVar(&mode, "mode", modeRun, "Internal: mode of the init (run, pid1 or app)")
+	runFlags.StringVar(&appConfig, "app-config", "", "Internal: how to run the app in app mode (JSON)")
+}
+
+// entrypoints are the subcommands of the init, by name
+var entrypoints = map[string]func(args []string) int{
+	"run":   runEntry,
+	"enter": common.EnterEntry,
+	"stop":  stopEntry,
+}
+
+func main() {
+	if len(os.Args) < 2 || entrypoints[os.Args[1]] == nil {
+		fmt.Fprintln(os.Stderr, "Usage: init run|enter|stop [FLAGS]")
+		os.Exit(1)
+	}
+	os.Exit(entrypoints[os.Args[1]](os.Args[2:]))
+}
+
+// runEntry runs the init in the mode it is given
+func runEntry(args []string) int {
+	runFlags.Parse(args)
+
+	switch mode {
+	case modeRun:
+		return run()
+	case modePid1:
+		return runPid1()
+	case modeApp:
+		return runApp()
+	}
+	fmt.Fprintf(os.Stderr, "Unknown mode %q\n", mode)
+	return 1
+}
+
+// run starts the init of the container and returns the exit status of the
+// main app once it has exited
+func run() int {
+	root := "."
+
+	if privateNet || len(ports) > 0 || privateUsers != "" {
+		fmt.Fprintln(os.Stderr, "Private networks, published ports and private users are not supported by this stage1")
+		return 4
+	}
+
+	c, err := common.LoadContainer(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load container: %v\n", err)
+		return 1
+	}
+	if err := c.CheckUUID(uuid); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	args := []string{selfExe, "run", "--mode=" + modePid1}
+	if debug {
+		args = append(args, "--debug")
+	}
+	cmd := &exec.Cmd{
+		Path:   selfExe,
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC,
+		},
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the init of the container: %v\n", err)
+		return 5
+	}
+
+	// as with nspawn, the recorded process is the parent of the init of
+	// the container
+	pidPath := path.PidPath(root)
+	if err := ioutil.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write pid file: %v\n", err)
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 5
+	}
+	defer os.Remove(pidPath)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigc {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	signal.Stop(sigc)
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		fmt.Fprintf(os.Stderr, "Failed to wait for the init of the container: %v\n", err)
+		return 5
+	}
+	status, err := c.MainAppStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get exit status of main app: %v\n", err)
+		return 7
+	}
+	return status
+}
+
+// stringList implements the flag.Value interface to contain repeated flags
+type stringList []string
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
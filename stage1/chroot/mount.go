@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/common"
+)
+
+// maxSymlinks is how many symlinks resolveInRoot follows before giving up,
+// like the kernel does on loops
+const maxSymlinks = 40
+
+// apiMounts are the API filesystems mounted in each rootfs of the container
+var apiMounts = []struct {
+	source string
+	target string
+	fstype string
+	flags  uintptr
+	data   string
+}{
+	{"proc", "/proc", "proc", syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV, ""},
+	{"sysfs", "/sys", "sysfs", syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV | syscall.MS_RDONLY, ""},
+	{"tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID | syscall.MS_STRICTATIME, "mode=755"},
+	{"devpts", "/dev/pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=620"},
+	{"tmpfs", "/dev/shm", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV, "mode=1777"},
+}
+
+// devices are the device nodes of the host bind mounted in each rootfs
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// devLinks are the symlinks created in the /dev of each rootfs
+var devLinks = map[string]string{
+	"ptmx":   "pts/ptmx",
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+}
+
+// setupMounts sets up the filesystems of the container in the mount namespace
+// of its init: the rootfs of stage1 and of each app, with API filesystems,
+// and the volumes of the apps. The rootfs of stage1 then becomes the root.
+func setupMounts(c *common.Container) error {
+	// nothing is propagated back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("error making mounts private: %v", err)
+	}
+
+	s1, err := filepath.Abs(rktpath.Stage1RootfsPath(c.Root))
+	if err != nil {
+		return err
+	}
+	if err := mountRootfs(s1); err != nil {
+		return err
+	}
+
+	vols := make(map[types.ACName]types.Volume)
+	for _, v := range c.Manifest.Volumes {
+		for _, f := range v.Fulfills {
+			vols[f] = v
+		}
+	}
+	for _, a := range c.Manifest.Apps {
+		rootfs, err := filepath.Abs(rktpath.AppRootfsPath(c.Root, a.Name))
+		if err != nil {
+			return err
+		}
+		if err := mountRootfs(rootfs); err != nil {
+			return fmt.Errorf("error setting up app %q: %v", a.Name, err)
+		}
+		for _, mp := range c.Apps[a.Name.String()].MountPoints {
+			vol, ok := vols[mp.Name]
+			if !ok {
+				return fmt.Errorf("no volume for mountpoint %q in app %q", mp.Name, a.Name)
+			}
+			target, err := resolveInRoot(rootfs, mp.Path)
+			if err != nil {
+				return fmt.Errorf("error resolving mountpoint %q in app %q: %v", mp.Name, a.Name, err)
+			}
+			if err := bindMount(vol.Source, target, mp.ReadOnly); err != nil {
+				return fmt.Errorf("error mounting volume %q in app %q: %v", mp.Name, a.Name, err)
+			}
+		}
+	}
+
+	return pivotRoot(s1)
+}
+
+// mountRootfs bind mounts the rootfs at root on itself, so that it can be
+// pivoted to, and mounts the API filesystems in it
+func mountRootfs(root string) error {
+	if err := bindMount(root, root, false); err != nil {
+		return err
+	}
+	for _, m := range apiMounts {
+		target, err := resolveInRoot(root, m.target)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.source, target, m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("error mounting %s: %v", target, err)
+		}
+	}
+	// /dev is the tmpfs mounted above by now
+	dev, err := resolveInRoot(root, "/dev")
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if err := bindMount(filepath.Join("/dev", d), filepath.Join(dev, d), false); err != nil {
+			return err
+		}
+	}
+	for n, t := range devLinks {
+		if err := os.Symlink(t, filepath.Join(dev, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveInRoot returns the path on the host of the path p inside the rootfs
+// at root. The rootfs comes from an image, so symlinks are followed as if root
+// were the root: none of them leads out of it. Components which do not exist
+// are kept as they are, to be created.
+func resolveInRoot(root, p string) (string, error) {
+	resolved := "/"
+	components := strings.Split(p, "/")
+	links := 0
+	for len(components) > 0 {
+		c := components[0]
+		components = components[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+
+		next := filepath.Join(resolved, c)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links resolving %s in %s", p, root)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		components = append(strings.Split(target, "/"), components...)
+	}
+	return filepath.Join(root, resolved), nil
+}
+
+// bindMount bind mounts source on target, creating target after the type of
+// source if it does not exist. A target in a rootfs must have been resolved
+// with resolveInRoot.
+func bindMount(source, target string, readOnly bool) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(target, os.O_CREATE|syscall.O_NOFOLLOW, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("error bind mounting %s on %s: %v", source, target, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	// bind mounts only become read-only once remounted
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("error remounting %s read-only: %v", target, err)
+	}
+	return nil
+}
+
+// pivotRoot makes the mount point root the root of the mount namespace,
+// detaching the former root
+func pivotRoot(root string) error {
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+	// the former root ends up on top of the new one, which saves creating
+	// a directory to put it in
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("error pivoting to %s: %v", root, err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("error detaching the former root: %v", err)
+	}
+	return os.Chdir("/")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root, err := ioutil.TempDir("", "rkt-chroot")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, d := range []string{"usr/lib", "var"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			t.Fatalf("error creating directory: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatalf("error creating file: %v", err)
+	}
+	links := map[string]string{
+		"lib":          "usr/lib",
+		"etc":          "/",
+		"var/run":      "../run",
+		"var/escape":   "../../../..",
+		"usr/lib/host": "/tmp",
+		"usr/lib/up":   "..",
+		"loop":         "loop",
+	}
+	for l, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, l)); err != nil {
+			t.Fatalf("error creating symlink: %v", err)
+		}
+	}
+
+	tests := []struct {
+		in  string
+		out string // relative to root, "" if an error is expected
+	}{
+		{"/usr/lib", "usr/lib"},
+		{"usr/./lib/", "usr/lib"},
+		{"/lib/x", "usr/lib/x"},
+		{"/etc/passwd", "passwd"},
+		{"/var/run/x", "run/x"},
+		{"/var/escape/usr", "usr"},
+		{"/../../tmp", "tmp"},
+		{"/lib/host/x", "tmp/x"},
+		{"/lib/up/lib/up/x", "usr/x"},
+		{"/missing/../x", "x"},
+		{"/", "."},
+		{"/file/x", ""},
+		{"/loop", ""},
+	}
+	for i, tt := range tests {
+		out, err := resolveInRoot(root, tt.in)
+		if tt.out == "" {
+			if err == nil {
+				t.Errorf("#%d: expected error resolving %q, got %q", i, tt.in, out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if want := filepath.Join(root, tt.out); out != want {
+			t.Errorf("#%d: got %q, want %q", i, out, want)
+		}
+	}
+}
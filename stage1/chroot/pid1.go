@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/coreos/rocket/app-container/schema"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/common"
+)
+
+// stopTimeout is how long the apps are given to exit once they have been
+// asked to, before they are killed
+const stopTimeout = 10 * time.Second
+
+// app is an app of the container, as supervised by its init
+type app struct {
+	*schema.App
+	cfg      appCfg
+	main     bool
+	pid      int // PID of the app process, 0 if it is not running
+	restarts int
+	startup  *startup // startup of the app process, nil before the first
+	// pending is the pending restart of the app, if any, and lastStatus
+	// the exit status it is restarted after
+	pending    *time.Timer
+	lastStatus int
+}
+
+// startup is the startup of an app process, as reported by its helper on
+// startupFd
+type startup struct {
+	done    chan struct{} // closed once the app is running or has failed to
+	running bool
+}
+
+// wait waits for the app to be running, and reports whether it is
+func (s *startup) wait() bool {
+	<-s.done
+	return s.running
+}
+
+// exit is the exit of a child of the init
+type exit struct {
+	pid    int
+	status syscall.WaitStatus
+}
+
+// runPid1 runs the init of the container: it sets up the filesystems of the
+// container, runs the apps and reaps them. The container goes down once its
+// main app has exited for good, or the init is asked to stop.
+func runPid1() int {
+	c, err := common.LoadContainer(".")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load container: %v\n", err)
+		return 1
+	}
+	if err := syscall.Sethostname([]byte("rkt-" + c.Manifest.UUID.String())); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set hostname: %v\n", err)
+		return 1
+	}
+	if err := setupMounts(c); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up mounts: %v\n", err)
+		return 1
+	}
+
+	apps, err := prepareApps(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to prepare apps: %v\n", err)
+		return 1
+	}
+
+	exits := make(chan exit)
+	go reap(exits)
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	restarts := make(chan *app)
+	var kill <-chan time.Time
+	stopping := false
+
+	if err := startApps(apps); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start apps: %v\n", err)
+		stopping = true
+		kill = stopApps(apps)
+	}
+
+	for {
+		if stopping {
+			running := false
+			for _, a := range apps {
+				running = running || a.pid != 0
+			}
+			if !running {
+				return 0
+			}
+		}
+
+		select {
+		case e := <-exits:
+			a := appByPid(apps, e.pid)
+			if a == nil {
+				// an orphan
+				continue
+			}
+			a.pid = 0
+			status := exitStatus(e.status)
+			if !stopping && a.restart(status) {
+				a.restarts++
+				backoff, _ := a.RestartPolicy.BackoffDuration()
+				a.lastStatus = status
+				a.pending = time.AfterFunc(backoff, func() { restarts <- a })
+				continue
+			}
+			a.finish(status)
+			// apps go down with the main app, or the apps they
+			// depend on
+			if a.main && !stopping {
+				stopping = true
+				kill = stopApps(apps)
+			} else {
+				stopDependents(apps, a)
+			}
+		case a := <-restarts:
+			// the app was finished if it was stopped in the meantime
+			if a.pending == nil {
+				continue
+			}
+			a.pending = nil
+			if err := a.start(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to restart app %q: %v\n", a.Name, err)
+				a.finish(1)
+			}
+		case <-sigc:
+			if !stopping {
+				stopping = true
+				kill = stopApps(apps)
+			}
+		case <-kill:
+			// everything but the init
+			syscall.Kill(-1, syscall.SIGKILL)
+		}
+	}
+}
+
+// prepareApps resolves how the apps of the container run, and returns them
+// in the order they start in
+func prepareApps(c *common.Container) ([]*app, error) {
+	var apps []*app
+	for i, a := range c.Manifest.Apps {
+		am := a.ApplyTo(*c.Apps[a.Name.String()])
+		rootfs := rktpath.RelAppRootfsPath(a.Name)
+		usr, err := common.AppUser(rootfs, am.User)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve user %q of app %q: %v", am.User, a.Name, err)
+		}
+		gid, err := common.AppGroup(rootfs, am.Group)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve group %q of app %q: %v", am.Group, a.Name, err)
+		}
+		env := common.AppEnvironment(am, a.Name, usr, c.MetadataURL)
+		// the environment is recorded for rkt enter too
+		envPath := rktpath.RelAppEnvPath(a.Name)
+		if err := os.MkdirAll(filepath.Dir(envPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := common.WriteEnvFile(envPath, env); err != nil {
+			return nil, fmt.Errorf("failed to write environment file of app %q: %v", a.Name, err)
+		}
+
+		cfg := appCfg{
+			Rootfs:   rootfs,
+			Exec:     am.Exec,
+			Uid:      usr.Uid,
+			Gid:      gid,
+			Handlers: make(map[string][]string),
+		}
+		for k, v := range env {
+			cfg.Env = append(cfg.Env, k+"="+v)
+		}
+		for _, eh := range am.EventHandlers {
+			switch eh.Name {
+			case "pre-start", "post-start", "pre-stop", "post-stop":
+				cfg.Handlers[eh.Name] = eh.Exec
+			default:
+				fmt.Fprintf(os.Stderr, "Warning: %s handler of app %q is not supported by this stage1\n", eh.Name, a.Name)
+			}
+		}
+		for _, p := range am.Ports {
+			if p.SocketActivated {
+				fmt.Fprintf(os.Stderr, "Warning: socket activation of app %q is not supported by this stage1\n", a.Name)
+				break
+			}
+		}
+		for _, d := range a.After {
+			if d.Ready {
+				fmt.Fprintf(os.Stderr, "Warning: readiness notification is not supported by this stage1: app %q starts once %q is running\n", a.Name, d.App)
+			}
+		}
+		apps = append(apps, &app{App: &c.Manifest.Apps[i], cfg: cfg, main: i == 0})
+	}
+	return startOrder(apps), nil
+}
+
+// startOrder returns the apps ordered so that each one comes after the ones
+// it depends on (which the container runtime manifest guarantees to be
+// possible)
+func startOrder(apps []*app) []*app {
+	var ordered []*app
+	added := make(map[*app]bool)
+	var add func(a *app)
+	add = func(a *app) {
+		if added[a] {
+			return
+		}
+		added[a] = true
+		for _, d := range a.After {
+			for _, da := range apps {
+				if da.Name.Equals(d.App) {
+					add(da)
+				}
+			}
+		}
+		ordered = append(ordered, a)
+	}
+	for _, a := range apps {
+		add(a)
+	}
+	return ordered
+}
+
+// startApps starts the apps in the given order, each one once the apps it
+// depends on are running. If an app fails to start, its exit status is
+// recorded and the apps after it are not started.
+func startApps(apps []*app) error {
+	for _, a := range apps {
+		if err := a.startAfterDeps(apps); err != nil {
+			a.finish(1)
+			return fmt.Errorf("failed to start app %q: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// startAfterDeps starts the app once the apps it depends on are running
+func (a *app) startAfterDeps(apps []*app) error {
+	for _, d := range a.After {
+		for _, da := range apps {
+			if da.Name.Equals(d.App) && (da.startup == nil || !da.startup.wait()) {
+				return fmt.Errorf("app %q it depends on is not running", d.App)
+			}
+		}
+	}
+	return a.start()
+}
+
+// appCmd returns the command running the helper of an app in app mode, with
+// the given config. Tests replace it.
+var appCmd = func(cfg appCfg) (*exec.Cmd, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &exec.Cmd{
+		Path: selfExe,
+		Args: []string{selfExe, "run", "--mode=" + modeApp, "--app-config=" + string(b)},
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: syscall.CLONE_NEWNS,
+		},
+	}, nil
+}
+
+// start starts the app in app mode
+func (a *app) start() error {
+	cmd, err := appCmd(a.cfg)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	// the helper gets the write end as startupFd
+	cmd.ExtraFiles = []*os.File{w}
+	// the process is reaped by reap
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return err
+	}
+	a.pid = cmd.Process.Pid
+
+	s := &startup{done: make(chan struct{})}
+	a.startup = s
+	go func() {
+		// nothing but EOF comes if the helper exits before the app runs
+		n, _ := r.Read(make([]byte, 1))
+		r.Close()
+		s.running = n == 1
+		close(s.done)
+	}()
+	return nil
+}
+
+// restart reports whether the app is to be restarted, after it has exited
+// with the given status
+func (a *app) restart(status int) bool {
+	rp := a.RestartPolicy
+	if rp == nil {
+		return false
+	}
+	switch rp.Policy {
+	case "always":
+		return true
+	case "on-failure":
+		return status != 0 && (rp.MaxAttempts == 0 || a.restarts < rp.MaxAttempts)
+	}
+	return false
+}
+
+// finish records the exit status of the app, which has exited for good
+func (a *app) finish(status int) {
+	fn := rktpath.RelAppStatusPath(a.Name)
+	err := os.MkdirAll(filepath.Dir(fn), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(fn, []byte(strconv.Itoa(status)), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record exit status of app %q: %v\n", a.Name, err)
+	}
+}
+
+// stop asks the app to stop if it is running. If it is waiting to be
+// restarted instead, it is not, and the status it last exited with is
+// recorded.
+func (a *app) stop() {
+	if a.pid != 0 {
+		syscall.Kill(a.pid, syscall.SIGTERM)
+		return
+	}
+	if a.pending != nil {
+		// the restart may be on its way to the init already, which
+		// ignores it once pending is reset
+		a.pending.Stop()
+		a.pending = nil
+		a.finish(a.lastStatus)
+	}
+}
+
+// stopApps asks the apps to stop, and returns a channel on which the time to
+// kill them comes
+func stopApps(apps []*app) <-chan time.Time {
+	for _, a := range apps {
+		a.stop()
+	}
+	return time.After(stopTimeout)
+}
+
+// stopDependents asks the apps depending on the given app to stop
+func stopDependents(apps []*app, dep *app) {
+	for _, a := range apps {
+		for _, d := range a.After {
+			if d.App.Equals(dep.Name) {
+				a.stop()
+			}
+		}
+	}
+}
+
+func appByPid(apps []*app, pid int) *app {
+	for _, a := range apps {
+		if a.pid == pid {
+			return a
+		}
+	}
+	return nil
+}
+
+// reap reaps the children of the init, including the orphans it inherits,
+// and sends their exits to the given channel
+func reap(exits chan<- exit) {
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		switch {
+		case err == syscall.ECHILD:
+			// no children for now
+			time.Sleep(100 * time.Millisecond)
+		case err != nil:
+		default:
+			exits <- exit{pid, ws}
+		}
+	}
+}
+
+// exitStatus returns the exit status of a process the way systemd reports it:
+// the number of the signal it was killed by, if it was
+func exitStatus(ws syscall.WaitStatus) int {
+	if ws.Signaled() {
+		return int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	rktpath "github.com/coreos/rocket/path"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+func TestStartOrder(t *testing.T) {
+	newApp := func(name string, after ...string) *app {
+		a := &schema.App{Name: types.ACName(name)}
+		for _, d := range after {
+			a.After = append(a.After, types.AppDependency{App: types.ACName(d)})
+		}
+		return &app{App: a}
+	}
+	apps := []*app{
+		newApp("main", "db", "cache"),
+		newApp("cache", "db"),
+		newApp("db"),
+		newApp("sidekick"),
+	}
+
+	var got []string
+	for _, a := range startOrder(apps) {
+		got = append(got, a.Name.String())
+	}
+	want := []string{"db", "cache", "main", "sidekick"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+// testApps returns apps running the given shell scripts, each one after the
+// apps named after it
+func testApps(scripts map[string]string, after map[string][]string) []*app {
+	var apps []*app
+	for _, name := range []string{"main", "db", "cache"} {
+		a := &schema.App{Name: types.ACName(name)}
+		for _, d := range after[name] {
+			a.After = append(a.After, types.AppDependency{App: types.ACName(d)})
+		}
+		apps = append(apps, &app{App: a, cfg: appCfg{Exec: []string{scripts[name]}}})
+	}
+	return apps
+}
+
+func TestStartApps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-chroot")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	// exit statuses are recorded relative to the stage1 root
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("error changing directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	defer func(f func(appCfg) (*exec.Cmd, error)) { appCmd = f }(appCmd)
+	appCmd = func(cfg appCfg) (*exec.Cmd, error) {
+		return exec.Command("/bin/sh", "-c", cfg.Exec[0]), nil
+	}
+	log := filepath.Join(dir, "log")
+	// apps log their start, and when they report running on startupFd
+	script := func(name, beforeRunning string) string {
+		return "echo start " + name + " >> " + log + "; " + beforeRunning +
+			"echo running " + name + " >> " + log + "; printf x >&3; exec sleep 10"
+	}
+	after := map[string][]string{"main": {"db", "cache"}, "cache": {"db"}}
+
+	apps := startOrder(testApps(map[string]string{
+		"main":  script("main", ""),
+		"db":    script("db", "sleep 0.2; "),
+		"cache": script("cache", "sleep 0.1; "),
+	}, after))
+	err = startApps(apps)
+	for _, a := range apps {
+		if a.pid != 0 {
+			a.startup.wait()
+			syscall.Kill(a.pid, syscall.SIGKILL)
+			syscall.Wait4(a.pid, nil, 0, nil)
+		}
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("error reading log: %v", err)
+	}
+	got := strings.TrimSpace(string(b))
+	want := "start db\nrunning db\nstart cache\nrunning cache\nstart main\nrunning main"
+	if got != want {
+		t.Errorf("got start log %q, want %q", got, want)
+	}
+
+	// apps do not start if an app they depend on fails to
+	os.Remove(log)
+	apps = startOrder(testApps(map[string]string{
+		"main":  script("main", ""),
+		"db":    script("db", "exit 1; "),
+		"cache": script("cache", ""),
+	}, after))
+	err = startApps(apps)
+	for _, a := range apps {
+		if a.pid != 0 {
+			syscall.Wait4(a.pid, nil, 0, nil)
+		}
+	}
+	if err == nil {
+		t.Fatalf("expected error starting apps")
+	}
+	if b, _ := ioutil.ReadFile(log); strings.TrimSpace(string(b)) != "start db" {
+		t.Errorf("got start log %q, want only db started", b)
+	}
+	b, err = ioutil.ReadFile(rktpath.RelAppStatusPath("cache"))
+	if err != nil || string(b) != "1" {
+		t.Errorf("exit status of cache not recorded as 1: %q, %v", b, err)
+	}
+}
+
+func TestStopPendingRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-chroot")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("error changing directory: %v", err)
+	}
+	defer os.Chdir(wd)
+
+	restarted := make(chan *app, 3)
+	apps := testApps(nil, map[string][]string{"main": {"db"}})
+	for i, a := range apps {
+		a := a
+		a.lastStatus = i + 1
+		a.pending = time.AfterFunc(time.Hour, func() { restarted <- a })
+	}
+
+	// the apps depending on an app that exited for good are stopped
+	stopDependents(apps, apps[1])
+	if apps[0].pending != nil {
+		t.Errorf("restart of main still pending")
+	}
+	for i, a := range apps[1:] {
+		if a.pending == nil {
+			t.Errorf("#%d: restart of %s canceled", i, a.Name)
+		}
+	}
+
+	stopApps(apps)
+	for i, a := range apps {
+		if a.pending != nil {
+			t.Errorf("#%d: restart of %s still pending", i, a.Name)
+		}
+		b, err := ioutil.ReadFile(rktpath.RelAppStatusPath(a.Name))
+		if want := strconv.Itoa(i + 1); err != nil || string(b) != want {
+			t.Errorf("#%d: exit status of %s not recorded as %s: %q, %v", i, a.Name, want, b, err)
+		}
+	}
+	select {
+	case a := <-restarted:
+		t.Errorf("unexpected restart of %s", a.Name)
+	default:
+	}
+}
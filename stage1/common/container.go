@@ -0,0 +1,90 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// Container encapsulates a ContainerRuntimeManifest and AppManifests
+type Container struct {
+	Root     string // root directory where the container will be located
+	Manifest *schema.ContainerRuntimeManifest
+	Apps     map[string]*schema.AppManifest
+	// MetadataURL is the URL of the metadata service the container is
+	// registered with, if it is
+	MetadataURL string
+}
+
+// LoadContainer loads a Container Runtime Manifest (as prepared by stage0) and
+// its associated Application Manifests, under $root/stage1/opt/stage2/$appname
+func LoadContainer(root string) (*Container, error) {
+	c := &Container{
+		Root: root,
+		Apps: make(map[string]*schema.AppManifest),
+	}
+
+	buf, err := ioutil.ReadFile(rktpath.ContainerManifestPath(c.Root))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading container runtime manifest: %v", err)
+	}
+
+	cm := &schema.ContainerRuntimeManifest{}
+	if err := json.Unmarshal(buf, cm); err != nil {
+		return nil, fmt.Errorf("failed unmarshalling container runtime manifest: %v", err)
+	}
+	c.Manifest = cm
+
+	for _, app := range c.Manifest.Apps {
+		ampath := rktpath.AppManifestPath(c.Root, app.Name)
+		buf, err := ioutil.ReadFile(ampath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading app manifest %q: %v", ampath, err)
+		}
+
+		am := &schema.AppManifest{}
+		if err = json.Unmarshal(buf, am); err != nil {
+			return nil, fmt.Errorf("failed unmarshalling app manifest %q: %v", ampath, err)
+		}
+		name := app.Name.String()
+		if _, ok := c.Apps[name]; ok {
+			return nil, fmt.Errorf("got multiple definitions for app: %s", name)
+		}
+		c.Apps[name] = am
+	}
+
+	return c, nil
+}
+
+// MainAppStatus returns the exit status of the main app of the container
+// (the first one in the container runtime manifest), as recorded in the
+// container directory once the app has exited.
+func (c *Container) MainAppStatus() (int, error) {
+	if len(c.Manifest.Apps) == 0 {
+		return 0, fmt.Errorf("container has no apps")
+	}
+	b, err := ioutil.ReadFile(rktpath.AppStatusPath(c.Root, c.Manifest.Apps[0].Name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// CheckUUID verifies that the container is the one with the given UUID, as
+// passed to the stage1 entrypoints
+func (c *Container) CheckUUID(uuid string) error {
+	u, err := types.NewUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("bad UUID %q: %v", uuid, err)
+	}
+	if *u != c.Manifest.UUID {
+		return fmt.Errorf("container %s is not %s", c.Manifest.UUID, u)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/proc"
+)
+
+// InitPid returns the PID of the init of the running container in root: the
+// child of the process recorded in its pid file
+func InitPid(root string) (int, error) {
+	b, err := ioutil.ReadFile(rktpath.PidPath(root))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read container pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("bad container pid: %v", err)
+	}
+	ipid, err := proc.ChildPid(pid)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find the init of the container: %v", err)
+	}
+	return ipid, nil
+}
+
+// NsenterArgs returns the nsenter(1) command line, up to the command to run,
+// entering the namespaces of the container in root whose init has the PID
+// ipid, in the directory dir of the stage1 rootfs
+func NsenterArgs(root string, ipid int, dir string) ([]string, error) {
+	bin, err := exec.LookPath("nsenter")
+	if err != nil {
+		return nil, err
+	}
+	// dir is opened before entering the mount namespace, through the root
+	// of the init of the container
+	dir = filepath.Join("/proc", strconv.Itoa(ipid), "root", dir)
+	args := []string{
+		bin,
+		"--target=" + strconv.Itoa(ipid),
+		"--mount", "--pid", "--net", "--uts", "--ipc",
+		"--root=" + dir,
+		"--wd=" + dir,
+	}
+	if _, err := os.Stat(rktpath.PrivateUsersPath(root)); err == nil {
+		args = append(args, "--user")
+	}
+	return args, nil
+}
+
+// AppEnv returns the environment of the given app, as recorded by stage1
+func AppEnv(root string, appName types.ACName) ([]string, error) {
+	b, err := ioutil.ReadFile(rktpath.AppEnvPath(root, appName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read environment of app %s: %v", appName, err)
+	}
+	var env []string
+	for _, e := range bytes.Split(b, []byte{0}) {
+		if len(e) > 0 {
+			env = append(env, string(e))
+		}
+	}
+	return env, nil
+}
+
+// Enter executes the command args as root in the namespaces of the running
+// container in root, in the rootfs and with the environment of the given app.
+// It only returns on error.
+func Enter(root string, appName types.ACName, args []string) error {
+	ipid, err := InitPid(root)
+	if err != nil {
+		return err
+	}
+	env, err := AppEnv(root, appName)
+	if err != nil {
+		return err
+	}
+	nargs, err := NsenterArgs(root, ipid, rktpath.RelAppRootfsPath(appName))
+	if err != nil {
+		return err
+	}
+	nargs = append(nargs, args...)
+	if err := syscall.Exec(nargs[0], nargs, env); err != nil {
+		return fmt.Errorf("error execing nsenter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+)
+
+// EntryFlags returns the flag set of the given stage1 entrypoint, with the
+// flags all of them take (cf. Documentation/stage1-interface.md)
+func EntryFlags(name string, debug *bool, uuid *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.BoolVar(debug, "debug", false, "Run in debug mode")
+	fs.StringVar(uuid, "uuid", "", "UUID of the container")
+	return fs
+}
+
+// LoadEntryContainer loads the container the stage1 entrypoints run in (the
+// current directory), checking it is the one with the given UUID
+func LoadEntryContainer(uuid string) (*Container, error) {
+	c, err := LoadContainer(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container: %v", err)
+	}
+	if err := c.CheckUUID(uuid); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EnterEntry implements the enter entrypoint of stage1, running a command in
+// the rootfs of an app of the running container: it is the same for stage1
+// flavors whose apps have their rootfs under the one of stage1.
+func EnterEntry(args []string) int {
+	var (
+		debug     bool
+		uuid, app string
+	)
+	fs := EntryFlags("enter", &debug, &uuid)
+	fs.StringVar(&app, "app", "", "App to run the command as")
+	fs.Parse(args)
+
+	c, err := LoadEntryContainer(uuid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "No command to run")
+		return 1
+	}
+	if c.Manifest.Apps.Get(types.ACName(app)) == nil {
+		fmt.Fprintf(os.Stderr, "No app %q in container\n", app)
+		return 1
+	}
+	if err := Enter(c.Root, types.ACName(app), fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to enter container: %v\n", err)
+	}
+	return 1
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/pkg/user"
+)
+
+const (
+	// DefaultPath is the PATH of the apps, as defined by the spec
+	DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	// DefaultShell is the SHELL of the apps running as users that are not
+	// in the passwd database of their rootfs
+	DefaultShell = "/bin/sh"
+)
+
+// AppEnvironment returns the environment of the app as the spec mandates it:
+// a standard PATH, and the USER, LOGNAME, HOME and SHELL of the user the app
+// runs as. The environment of the app comes on top of it, AC_APP_NAME is
+// always the name of the app, and AC_METADATA_URL the given URL of the
+// metadata service, if the container is registered with one.
+func AppEnvironment(am *schema.AppManifest, appName types.ACName, u *user.User, metadataURL string) map[string]string {
+	env := map[string]string{
+		"PATH":    DefaultPath,
+		"USER":    u.Name,
+		"LOGNAME": u.Name,
+		"HOME":    u.Home,
+		"SHELL":   u.Shell,
+	}
+	for k, v := range am.Environment {
+		env[k] = v
+	}
+	env["AC_APP_NAME"] = appName.String()
+	if metadataURL != "" {
+		env["AC_METADATA_URL"] = metadataURL
+	}
+	return env
+}
+
+// WriteEnvFile writes the given environment to the file fn, as NUL-separated
+// NAME=VALUE entries (cf. rktpath.AppEnvPath)
+func WriteEnvFile(fn string, env map[string]string) error {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\x00", k, env[k])
+	}
+	return ioutil.WriteFile(fn, b.Bytes(), 0600)
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// MetadataSvcURL is the URL of the metadata service, as seen from the
+// containers registered with it (cf. AC_METADATA_URL)
+const MetadataSvcURL = "http://169.254.169.255"
+
+// MetadataSvcAvailable reports whether the metadata service is running on the
+// host, i.e. whether its registration socket exists
+func MetadataSvcAvailable() bool {
+	_, err := os.Stat(rktpath.MetadataSvcRegSock)
+	return err == nil
+}
+
+// RegisterContainer registers the container and the image manifests of its
+// apps with the metadata service, which serves them to the container at the
+// given IP. hostIf is the host side interface the container is reached
+// through, which only that IP may be used from. The service tells whether
+// the container still runs by the lock on its directory.
+func (c *Container) RegisterContainer(ip net.IP, hostIf string) error {
+	b, err := json.Marshal(c.Manifest)
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(c.Root)
+	if err != nil {
+		return err
+	}
+	q := url.Values{
+		"container_ip":     {ip.String()},
+		"container_brport": {hostIf},
+		"container_dir":    {dir},
+	}
+	if err := metadataSvcRequest("POST", "/containers/?"+q.Encode(), b); err != nil {
+		return fmt.Errorf("failed to register container: %v", err)
+	}
+
+	for _, a := range c.Manifest.Apps {
+		b, err := json.Marshal(c.Apps[a.Name.String()])
+		if err != nil {
+			return err
+		}
+		if err := metadataSvcRequest("PUT", "/containers/"+c.Manifest.UUID.String()+"/"+a.Name.String(), b); err != nil {
+			return fmt.Errorf("failed to register app %q: %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// UnregisterContainer removes the registration of the container with the
+// given UUID from the metadata service. It is not an error for the service
+// not to run or not to know the container.
+func UnregisterContainer(cuuid types.UUID) error {
+	if !MetadataSvcAvailable() {
+		return nil
+	}
+	err := metadataSvcRequest("DELETE", "/containers/"+cuuid.String(), nil)
+	if err == errNotRegistered {
+		return nil
+	}
+	return err
+}
+
+var errNotRegistered = errors.New("container not registered")
+
+// metadataSvcRequest sends a request with the given body to the registration
+// socket of the metadata service
+func metadataSvcRequest(method, path string, body []byte) error {
+	req, err := http.NewRequest(method, "http://metadata-svc"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	cli := http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", rktpath.MetadataSvcRegSock)
+			},
+		},
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errNotRegistered
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%s %s failed with %d: %s", method, path, resp.StatusCode, msg)
+}
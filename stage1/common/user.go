@@ -0,0 +1,70 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/pkg/user"
+)
+
+// AppUser resolves the user an app runs as, as given in its manifest, against
+// the rootfs of the app: a name must be in the passwd database of the rootfs,
+// while a numeric uid needs not be. A path stands for the owner of the file
+// at that path in the rootfs.
+func AppUser(rootfs, u string) (*user.User, error) {
+	if u == "" {
+		u = "0"
+	}
+	if strings.HasPrefix(u, "/") {
+		uid, _, err := user.LookupPath(rootfs, u)
+		if err != nil {
+			return nil, err
+		}
+		u = strconv.Itoa(uid)
+	}
+
+	uid, err := strconv.Atoi(u)
+	if err != nil {
+		return user.LookupUser(rootfs, u)
+	}
+	if uid < 0 {
+		return nil, fmt.Errorf("bad uid %d", uid)
+	}
+	usr, err := user.LookupUser(rootfs, u)
+	if _, ok := err.(user.UnknownUserError); ok {
+		// not all images come with a passwd database
+		usr = &user.User{Name: u, Uid: uid, Gid: -1, Home: "/", Shell: DefaultShell}
+		if uid == 0 {
+			usr.Name = "root"
+			usr.Home = "/root"
+		}
+		return usr, nil
+	}
+	return usr, err
+}
+
+// AppGroup resolves the group an app runs as, as given in its manifest,
+// against the rootfs of the app, in the same way as AppUser
+func AppGroup(rootfs, g string) (int, error) {
+	if g == "" {
+		g = "0"
+	}
+	if strings.HasPrefix(g, "/") {
+		_, gid, err := user.LookupPath(rootfs, g)
+		return gid, err
+	}
+
+	gid, err := strconv.Atoi(g)
+	if err != nil {
+		grp, err := user.LookupGroup(rootfs, g)
+		if err != nil {
+			return 0, err
+		}
+		return grp.Gid, nil
+	}
+	if gid < 0 {
+		return 0, fmt.Errorf("bad gid %d", gid)
+	}
+	return gid, nil
+}
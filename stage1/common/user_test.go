@@ -0,0 +1,82 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAppUserGroup(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "stage1-user")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatalf("error creating etc: %v", err)
+	}
+	files := map[string]string{
+		"etc/passwd": "svc:x:1000:100::/var/lib/svc:/bin/false\n",
+		"etc/group":  "svcs:x:100:\n",
+	}
+	for fn, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(rootfs, fn), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing %s: %v", fn, err)
+		}
+	}
+
+	users := []struct {
+		in   string
+		uid  int
+		name string
+		home string
+	}{
+		{"svc", 1000, "svc", "/var/lib/svc"},
+		{"1000", 1000, "svc", "/var/lib/svc"},
+		// numeric IDs need not be in the passwd database
+		{"2000", 2000, "2000", "/"},
+		{"0", 0, "root", "/root"},
+		{"", 0, "root", "/root"},
+	}
+	for i, tt := range users {
+		u, err := AppUser(rootfs, tt.in)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if u.Uid != tt.uid || u.Name != tt.name || u.Home != tt.home {
+			t.Errorf("#%d: got %d/%s/%s, want %d/%s/%s", i, u.Uid, u.Name, u.Home, tt.uid, tt.name, tt.home)
+		}
+	}
+	for _, in := range []string{"nobody", "-1"} {
+		if _, err := AppUser(rootfs, in); err == nil {
+			t.Errorf("expected error resolving user %q", in)
+		}
+	}
+
+	groups := []struct {
+		in  string
+		gid int
+	}{
+		{"svcs", 100},
+		{"100", 100},
+		{"3000", 3000},
+		{"", 0},
+	}
+	for i, tt := range groups {
+		gid, err := AppGroup(rootfs, tt.in)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if gid != tt.gid {
+			t.Errorf("#%d: got %d, want %d", i, gid, tt.gid)
+		}
+	}
+	for _, in := range []string{"nogroup", "-1"} {
+		if _, err := AppGroup(rootfs, in); err == nil {
+			t.Errorf("expected error resolving group %q", in)
+		}
+	}
+}
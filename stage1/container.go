@@ -1,124 +1,449 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
 	"github.com/coreos/rocket/app-container/schema"
 	"github.com/coreos/rocket/app-container/schema/types"
 	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/uid"
+	"github.com/coreos/rocket/stage1/common"
+)
+
+const (
+	// maxStartLimitInterval is the interval over which the restarts of an
+	// app are counted when their number is limited: the lifetime of the
+	// container, as far as systemd is concerned
+	maxStartLimitInterval = "100000d"
+	// journaldConf makes journald keep the logs of the apps, rotating its
+	// files as they grow
+	journaldConf = `[Journal]
+Storage=persistent
+SystemMaxUse=64M
+SystemMaxFileSize=8M
+`
 )
 
 // Container encapsulates a ContainerRuntimeManifest and AppManifests
-type Container struct {
-	Root     string // root directory where the container will be located
-	Manifest *schema.ContainerRuntimeManifest
-	Apps     map[string]*schema.AppManifest
-}
+type Container common.Container
 
 // LoadContainer loads a Container Runtime Manifest (as prepared by stage0) and
-// its associated Application Manifests, under $root/stage1/opt/stage1/$apphash
+// its associated Application Manifests, under $root/stage1/opt/stage2/$appname
 func LoadContainer(root string) (*Container, error) {
-	c := &Container{
-		Root: root,
-		Apps: make(map[string]*schema.AppManifest),
-	}
+	c, err := common.LoadContainer(root)
+	return (*Container)(c), err
+}
 
-	buf, err := ioutil.ReadFile(rktpath.ContainerManifestPath(c.Root))
+// appToSystemd transforms the provided app manifest into a systemd service unit
+// for the given app of the container
+func (c *Container) appToSystemd(am *schema.AppManifest, a *schema.App) error {
+	appName := a.Name
+	name := appName.String()
+	// systemd would resolve names against the passwd and group databases of
+	// stage1, so hand it the IDs from the ones of the app
+	rootfs := rktpath.AppRootfsPath(c.Root, appName)
+	usr, err := common.AppUser(rootfs, am.User)
 	if err != nil {
-		return nil, fmt.Errorf("failed reading container runtime manifest: %v", err)
+		return fmt.Errorf("failed to resolve user %q: %v", am.User, err)
 	}
-
-	cm := &schema.ContainerRuntimeManifest{}
-	if err := json.Unmarshal(buf, cm); err != nil {
-		return nil, fmt.Errorf("failed unmarshalling container runtime manifest: %v", err)
+	gid, err := common.AppGroup(rootfs, am.Group)
+	if err != nil {
+		return fmt.Errorf("failed to resolve group %q: %v", am.Group, err)
 	}
-	c.Manifest = cm
 
-	for _, app := range c.Manifest.Apps {
-		ampath := rktpath.AppManifestPath(c.Root, app.ImageID)
-		buf, err := ioutil.ReadFile(ampath)
+	// the app and its health check run in the same environment, their
+	// commands in the rootfs of the app and as its user (cf. appExec)
+	var ctx []*unit.UnitOption
+	env := common.AppEnvironment(am, appName, usr, c.MetadataURL)
+	var keys []string
+	for ek := range env {
+		keys = append(keys, ek)
+	}
+	sort.Strings(keys)
+	for _, ek := range keys {
+		ee, err := quoteEnv(ek, env[ek])
 		if err != nil {
-			return nil, fmt.Errorf("failed reading app manifest %q: %v", ampath, err)
-		}
-
-		am := &schema.AppManifest{}
-		if err = json.Unmarshal(buf, am); err != nil {
-			return nil, fmt.Errorf("failed unmarshalling app manifest %q: %v", ampath, err)
-		}
-		name := am.Name.String()
-		if _, ok := c.Apps[name]; ok {
-			return nil, fmt.Errorf("got multiple definitions for app: %s", name)
+			return err
 		}
-		c.Apps[name] = am
+		ctx = append(ctx, &unit.UnitOption{"Service", "Environment", ee})
+	}
+	// the environment is recorded for rkt enter too
+	if err := common.WriteEnvFile(rktpath.AppEnvPath(c.Root, appName), env); err != nil {
+		return fmt.Errorf("failed to write environment file: %v", err)
 	}
 
-	return c, nil
-}
-
-// appToSystemd transforms the provided app manifest into a systemd service unit
-func (c *Container) appToSystemd(am *schema.AppManifest, id types.Hash) error {
-	name := am.Name.String()
-	execStart := strings.Join(am.Exec, " ")
+	execStart := c.appExec(appName, usr.Uid, gid, am.Exec)
 	opts := []*unit.UnitOption{
 		&unit.UnitOption{"Unit", "Description", name},
 		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
-		&unit.UnitOption{"Unit", "OnFailureJobMode", "isolate"},
-		&unit.UnitOption{"Unit", "OnFailure", "reaper.service"},
 		&unit.UnitOption{"Unit", "Wants", "exit-watcher.service"},
-		&unit.UnitOption{"Service", "Restart", "no"},
-		&unit.UnitOption{"Service", "RootDirectory", rktpath.RelAppRootfsPath(id)},
 		&unit.UnitOption{"Service", "ExecStart", execStart},
-		&unit.UnitOption{"Service", "User", am.User},
-		&unit.UnitOption{"Service", "Group", am.Group},
+		// the output of the app is logged with the container UUID and
+		// app name (cf. logEntry); the one of stage1 under the app name
+		&unit.UnitOption{"Service", "SyslogIdentifier", name},
 	}
+	// The container goes down when its main app fails (for good, if it is
+	// restarted), while the other apps can fail on their own
+	if appName.Equals(c.Manifest.Apps[0].Name) {
+		opts = append(opts,
+			&unit.UnitOption{"Unit", "OnFailureJobMode", "isolate"},
+			&unit.UnitOption{"Unit", "OnFailure", "reaper.service"},
+		)
+	}
+	// an app is stopped when an app it starts after stops, even on its own
+	for _, d := range a.After {
+		opts = append(opts,
+			&unit.UnitOption{"Unit", "After", ServiceName(d.App)},
+			&unit.UnitOption{"Unit", "BindsTo", ServiceName(d.App)},
+		)
+	}
+	if c.notifiesReady(appName) {
+		if err := c.appToNotifySocket(appName); err != nil {
+			return err
+		}
+		// the readiness may be notified by any process of the app
+		opts = append(opts,
+			&unit.UnitOption{"Unit", "After", NotifySocketServiceName(appName)},
+			&unit.UnitOption{"Unit", "Requires", NotifySocketServiceName(appName)},
+			&unit.UnitOption{"Service", "Type", "notify"},
+			&unit.UnitOption{"Service", "NotifyAccess", "all"},
+		)
+	}
+	ropts, err := restartOptions(a.RestartPolicy)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, ropts...)
 
-	for _, eh := range am.EventHandlers {
+	var healthCheck *types.EventHandler
+	for i, eh := range am.EventHandlers {
 		var typ string
 		switch eh.Name {
 		case "pre-start":
 			typ = "ExecStartPre"
+		case "post-start":
+			typ = "ExecStartPost"
+		case "pre-stop":
+			typ = "ExecStop"
 		case "post-stop":
 			typ = "ExecStopPost"
+		case "health-check":
+			healthCheck = &am.EventHandlers[i]
+			continue
 		default:
 			return fmt.Errorf("unrecognized eventHandler: %v", eh.Name)
 		}
-		exec := strings.Join(eh.Exec, " ")
+		exec := c.appExec(appName, usr.Uid, gid, eh.Exec)
 		opts = append(opts, &unit.UnitOption{"Service", typ, exec})
 	}
+	opts = append(opts, ctx...)
 
-	env := am.Environment
-	env["AC_APP_NAME"] = name
-	for ek, ev := range env {
-		ee := fmt.Sprintf(`"%s=%s"`, ek, ev)
-		opts = append(opts, &unit.UnitOption{"Service", "Environment", ee})
+	if healthCheck != nil {
+		exec := c.appExec(appName, usr.Uid, gid, healthCheck.Exec)
+		if err := c.appToHealthCheck(healthCheck, appName, exec, ctx); err != nil {
+			return err
+		}
+		opts = append(opts, &unit.UnitOption{"Unit", "Wants", HealthCheckTimerName(appName)})
 	}
 
-	file, err := os.OpenFile(ServiceFilePath(c.Root, id), os.O_WRONLY|os.O_CREATE, 0644)
+	if err := writeUnit(ServiceFilePath(c.Root, appName), opts); err != nil {
+		return fmt.Errorf("failed to write service file: %v", err)
+	}
+
+	// A socket-activated app is only started by its socket, on the first
+	// connection; otherwise it is started right away
+	activated, err := c.appToSocket(am, appName)
 	if err != nil {
-		return fmt.Errorf("failed to create service file: %v", err)
+		return err
+	}
+	if activated {
+		return nil
+	}
+	if err = os.Symlink(path.Join("..", ServiceName(appName)), WantLinkPath(c.Root, appName)); err != nil {
+		return fmt.Errorf("failed to link service want: %v", err)
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, unit.Serialize(opts))
+	return nil
+}
+
+// notifiesReady reports whether another app of the container starts once the
+// given app is ready, which the app then has to notify
+func (c *Container) notifiesReady(appName types.ACName) bool {
+	for _, a := range c.Manifest.Apps {
+		for _, d := range a.After {
+			if d.Ready && d.App.Equals(appName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetupJournal configures the journald of stage1 to keep the logs of the
+// apps. If inContainer is set, its journal files are kept in the container
+// directory, owned by the root of the user namespace of the container if ur
+// is not nil.
+func (c *Container) SetupJournal(inContainer bool, ur *uid.Range) error {
+	etc := filepath.Join(rktpath.Stage1RootfsPath(c.Root), "/etc/systemd")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(etc, "journald.conf"), []byte(journaldConf), 0644); err != nil {
+		return err
+	}
+	if !inContainer {
+		return nil
+	}
+	dir := rktpath.JournalPath(c.Root)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if ur != nil {
+		return os.Chown(dir, int(ur.Base), int(ur.Base))
+	}
+	return nil
+}
+
+// notifyingApps returns the apps of the container that notify their readiness
+func (c *Container) notifyingApps() []types.ACName {
+	var names []types.ACName
+	for _, a := range c.Manifest.Apps {
+		if c.notifiesReady(a.Name) {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+// SetupNotifyDir creates the directories holding the /run/systemd of stage1
+// and of the apps notifying their readiness, if any, owned by the root of the
+// user namespace of the container if ur is not nil (cf.
+// ContainerToNspawnArgs). The apps do not share the directory of stage1,
+// which holds the private sockets of systemd: theirs only get a link to its
+// notification socket, made by LinkNotifySocket once systemd runs.
+func (c *Container) SetupNotifyDir(ur *uid.Range) error {
+	names := c.notifyingApps()
+	if len(names) == 0 {
+		return nil
+	}
+	dirs := []string{SystemdRunPath(c.Root)}
+	for _, n := range names {
+		dirs = append(dirs, AppNotifyDirPath(c.Root, n))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		if ur != nil {
+			if err := os.Chown(dir, int(ur.Base), int(ur.Base)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LinkNotifySocket links the notification socket of the systemd of stage1
+// into the /run/systemd of the apps notifying their readiness, as soon as
+// systemd has created it, until stop is closed. The links are made from the
+// host, where both directories are on the same filesystem.
+func (c *Container) LinkNotifySocket(stop <-chan struct{}) {
+	names := c.notifyingApps()
+	if len(names) == 0 {
+		return
+	}
+	sock := filepath.Join(SystemdRunPath(c.Root), "notify")
+	for {
+		if fi, err := os.Lstat(sock); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			for _, n := range names {
+				l := filepath.Join(AppNotifyDirPath(c.Root, n), "notify")
+				if err := os.Link(sock, l); err != nil && !os.IsExist(err) {
+					fmt.Fprintf(os.Stderr, "Failed to link notification socket for app %q: %v\n", n, err)
+				}
+			}
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// appToNotifySocket creates the systemd service the given app, which
+// notifies its readiness, starts after: it waits, in the context of stage1,
+// for LinkNotifySocket to have linked the notification socket into the
+// rootfs of the app
+func (c *Container) appToNotifySocket(appName types.ACName) error {
+	sock := filepath.Join(rktpath.RelAppRootfsPath(appName), "/run/systemd/notify")
+	// escaped app names hold backslashes, but no single quotes
+	wait := fmt.Sprintf("until [ -S '%s' ]; do /usr/bin/sleep 0.01; done", sock)
+	opts := []*unit.UnitOption{
+		&unit.UnitOption{"Unit", "Description", appName.String() + " notification socket"},
+		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
+		&unit.UnitOption{"Service", "Type", "oneshot"},
+		&unit.UnitOption{"Service", "RemainAfterExit", "yes"},
+		&unit.UnitOption{"Service", "ExecStart", quoteExec([]string{"/usr/bin/bash", "-c", wait})},
+	}
+	if err := writeUnit(UnitFilePath(c.Root, NotifySocketServiceName(appName)), opts); err != nil {
+		return fmt.Errorf("failed to write notification socket service file: %v", err)
+	}
+	return nil
+}
+
+// restartOptions returns the options of the systemd service of an app
+// implementing the given restart policy. Apps are not restarted by default.
+func restartOptions(rp *types.RestartPolicy) ([]*unit.UnitOption, error) {
+	if rp == nil || rp.Policy == "never" {
+		return []*unit.UnitOption{
+			&unit.UnitOption{"Service", "Restart", "no"},
+		}, nil
+	}
+
+	backoff, err := rp.BackoffDuration()
 	if err != nil {
-		return fmt.Errorf("failed to write service file: %v", err)
+		return nil, err
+	}
+	opts := []*unit.UnitOption{
+		&unit.UnitOption{"Service", "Restart", rp.Policy},
+		&unit.UnitOption{"Service", "RestartSec", fmt.Sprintf("%dms", backoff/time.Millisecond)},
 	}
+	if rp.MaxAttempts == 0 {
+		// no limit
+		return append(opts, &unit.UnitOption{"Service", "StartLimitInterval", "0"}), nil
+	}
+	// the first start counts too; giving up makes the service fail
+	return append(opts,
+		&unit.UnitOption{"Service", "StartLimitInterval", maxStartLimitInterval},
+		&unit.UnitOption{"Service", "StartLimitBurst", strconv.Itoa(rp.MaxAttempts + 1)},
+	), nil
+}
 
-	if err = os.Symlink(path.Join("..", ServiceName(id)), WantLinkPath(c.Root, id)); err != nil {
-		return fmt.Errorf("failed to link service want: %v", err)
+// appToHealthCheck creates the systemd units running the health-check
+// handler eh of the given app, as the command line exec, on its interval, in
+// the context ctx of the app, for as long as the app runs. When the health
+// check fails, the app is restarted or killed (failing the container),
+// according to the policy of the handler.
+func (c *Container) appToHealthCheck(eh *types.EventHandler, appName types.ACName, exec string, ctx []*unit.UnitOption) error {
+	name := appName.String()
+	interval, err := eh.IntervalDuration()
+	if err != nil {
+		return err
 	}
 
+	var action string
+	switch eh.OnFailure {
+	case "", "restart":
+		action = "restart"
+	case "fail":
+		// SIGTERM would count as a clean exit
+		action = "kill --signal=SIGKILL"
+	default:
+		return fmt.Errorf("unrecognized health-check onFailure: %v", eh.OnFailure)
+	}
+	failed := []*unit.UnitOption{
+		&unit.UnitOption{"Unit", "Description", name + " health check failure"},
+		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
+		&unit.UnitOption{"Service", "Type", "oneshot"},
+		&unit.UnitOption{"Service", "ExecStart", fmt.Sprintf("/usr/bin/systemctl %s %s", action, ServiceName(appName))},
+	}
+	if err := writeUnit(UnitFilePath(c.Root, HealthFailedServiceName(appName)), failed); err != nil {
+		return fmt.Errorf("failed to write health check failure service file: %v", err)
+	}
+
+	check := []*unit.UnitOption{
+		&unit.UnitOption{"Unit", "Description", name + " health check"},
+		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
+		&unit.UnitOption{"Unit", "OnFailure", HealthFailedServiceName(appName)},
+		&unit.UnitOption{"Service", "Type", "oneshot"},
+		&unit.UnitOption{"Service", "ExecStart", exec},
+	}
+	check = append(check, ctx...)
+	if err := writeUnit(UnitFilePath(c.Root, HealthCheckServiceName(appName)), check); err != nil {
+		return fmt.Errorf("failed to write health check service file: %v", err)
+	}
+
+	// the timer starts the service of the same name
+	sec := fmt.Sprintf("%dms", interval/time.Millisecond)
+	timer := []*unit.UnitOption{
+		&unit.UnitOption{"Unit", "Description", name + " health check timer"},
+		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
+		&unit.UnitOption{"Unit", "BindsTo", ServiceName(appName)},
+		&unit.UnitOption{"Unit", "After", ServiceName(appName)},
+		&unit.UnitOption{"Timer", "OnActiveSec", sec},
+		&unit.UnitOption{"Timer", "OnUnitActiveSec", sec},
+		&unit.UnitOption{"Timer", "AccuracySec", "1ms"},
+	}
+	if err := writeUnit(UnitFilePath(c.Root, HealthCheckTimerName(appName)), timer); err != nil {
+		return fmt.Errorf("failed to write health check timer file: %v", err)
+	}
 	return nil
 }
 
+// appToSocket creates a systemd socket unit listening on the ports of the
+// app that request socket activation, and reports whether there were any.
+// systemd passes the listening sockets to the app through LISTEN_FDS.
+func (c *Container) appToSocket(am *schema.AppManifest, appName types.ACName) (bool, error) {
+	opts := []*unit.UnitOption{
+		&unit.UnitOption{"Unit", "Description", appName.String() + " socket"},
+		&unit.UnitOption{"Unit", "DefaultDependencies", "false"},
+	}
+	n := 0
+	for _, p := range am.Ports {
+		if !p.SocketActivated {
+			continue
+		}
+		var listen string
+		switch p.Protocol {
+		case "tcp":
+			listen = "ListenStream"
+		case "udp":
+			listen = "ListenDatagram"
+		default:
+			return false, fmt.Errorf("unsupported protocol %q for socket-activated port %q", p.Protocol, p.Name)
+		}
+		opts = append(opts, &unit.UnitOption{"Socket", listen, fmt.Sprint(p.Port)})
+		n++
+	}
+	if n == 0 {
+		return false, nil
+	}
+	// a single instance of the app gets all the listening sockets, rather
+	// than one per connection
+	opts = append(opts, &unit.UnitOption{"Socket", "Accept", "false"})
+
+	if err := writeUnit(SocketFilePath(c.Root, appName), opts); err != nil {
+		return false, fmt.Errorf("failed to write socket file: %v", err)
+	}
+	if err := os.Symlink(path.Join("..", SocketName(appName)), SocketWantLinkPath(c.Root, appName)); err != nil {
+		return false, fmt.Errorf("failed to link socket want: %v", err)
+	}
+	return true, nil
+}
+
+// writeUnit serializes the given unit options into the unit file fn
+func writeUnit(fn string, opts []*unit.UnitOption) error {
+	file, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, unit.Serialize(opts))
+	return err
+}
+
 // ContainerToSystemd creates the appropriate systemd service unit files for
 // all the constituent apps of the Container
 func (c *Container) ContainerToSystemd() error {
@@ -128,14 +453,17 @@ func (c *Container) ContainerToSystemd() error {
 	if err := os.MkdirAll(WantsPath(c.Root), 0640); err != nil {
 		return fmt.Errorf("failed to create wants directory: %v", err)
 	}
-	for _, am := range c.Apps {
-		a := c.Manifest.Apps.Get(am.Name)
-		if a == nil {
-			// should never happen
-			panic("app not found in container manifest")
-		}
-		if err := c.appToSystemd(am, a.ImageID); err != nil {
-			return fmt.Errorf("failed to transform app %q into systemd service: %v", am.Name, err)
+	// the reaper records the exit status of the apps in there
+	if err := os.MkdirAll(rktpath.StatusDirPath(c.Root), 0755); err != nil {
+		return fmt.Errorf("failed to create status directory: %v", err)
+	}
+	if err := os.MkdirAll(rktpath.EnvDirPath(c.Root), 0755); err != nil {
+		return fmt.Errorf("failed to create environment directory: %v", err)
+	}
+	for _, a := range c.Manifest.Apps {
+		am := a.ApplyTo(*c.Apps[a.Name.String()])
+		if err := c.appToSystemd(am, &a); err != nil {
+			return fmt.Errorf("failed to transform app %q into systemd service: %v", a.Name, err)
 		}
 	}
 
@@ -143,10 +471,10 @@ func (c *Container) ContainerToSystemd() error {
 }
 
 // appToNspawnArgs transforms the given app manifest, with the given associated
-// app image id, into a subset of applicable systemd-nspawn argument
-func (c *Container) appToNspawnArgs(am *schema.AppManifest, id types.Hash) ([]string, error) {
+// app name, into a subset of applicable systemd-nspawn argument
+func (c *Container) appToNspawnArgs(am *schema.AppManifest, appName types.ACName) ([]string, error) {
 	args := []string{}
-	name := am.Name.String()
+	name := appName.String()
 
 	vols := make(map[types.ACName]types.Volume)
 	for _, v := range c.Manifest.Volumes {
@@ -171,7 +499,7 @@ func (c *Container) appToNspawnArgs(am *schema.AppManifest, id types.Hash) ([]st
 
 		opt[1] = vol.Source
 		opt[2] = ":"
-		opt[3] = filepath.Join(rktpath.RelAppRootfsPath(id), mp.Path)
+		opt[3] = filepath.Join(rktpath.RelAppRootfsPath(appName), mp.Path)
 
 		args = append(args, strings.Join(opt, ""))
 	}
@@ -187,17 +515,33 @@ func (c *Container) ContainerToNspawnArgs() ([]string, error) {
 		"--directory=" + rktpath.Stage1RootfsPath(c.Root),
 	}
 
-	for _, am := range c.Apps {
-		a := c.Manifest.Apps.Get(am.Name)
-		if a == nil {
-			panic("could not find app in container manifest!")
-		}
-		aa, err := c.appToNspawnArgs(am, a.ImageID)
+	for _, a := range c.Manifest.Apps {
+		am := c.Apps[a.Name.String()]
+		aa, err := c.appToNspawnArgs(am, a.Name)
 		if err != nil {
-			return nil, fmt.Errorf("failed to construct args for app %q: %v", am.Name, err)
+			return nil, fmt.Errorf("failed to construct args for app %q: %v", a.Name, err)
 		}
 		args = append(args, aa...)
 	}
 
+	// systemd listens for readiness notifications in /run/systemd, which
+	// the apps cannot reach from their rootfs: each app notifying its
+	// readiness gets a directory of its own there, where the socket is
+	// linked to (cf. SetupNotifyDir)
+	if names := c.notifyingApps(); len(names) > 0 {
+		dir, err := filepath.Abs(SystemdRunPath(c.Root))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--bind="+dir+":/run/systemd")
+		for _, n := range names {
+			dir, err := filepath.Abs(AppNotifyDirPath(c.Root, n))
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, "--bind="+dir+":"+filepath.Join(rktpath.RelAppRootfsPath(n), "/run/systemd"))
+		}
+	}
+
 	return args, nil
 }
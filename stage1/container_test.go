@@ -0,0 +1,296 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/rocket/Godeps/_workspace/src/github.com/coreos/go-systemd/unit"
+	"github.com/coreos/rocket/app-container/schema"
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+)
+
+// newTestContainer returns a container in a temporary directory running the
+// given apps, each with the given app manifest and an empty rootfs
+func newTestContainer(t *testing.T, apps []schema.App, ams []*schema.AppManifest) *Container {
+	root, err := ioutil.TempDir("", "rkt-stage1")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	uuid, _ := types.NewUUID("6733c088-a507-4694-aabf-edbe4fc5266f")
+	c := &Container{
+		Root:     root,
+		Manifest: &schema.ContainerRuntimeManifest{UUID: *uuid, Apps: apps},
+		Apps:     make(map[string]*schema.AppManifest),
+	}
+	for i, a := range apps {
+		if err := os.MkdirAll(rktpath.AppRootfsPath(root, a.Name), 0755); err != nil {
+			t.Fatalf("error creating app rootfs: %v", err)
+		}
+		c.Apps[a.Name.String()] = ams[i]
+	}
+	return c
+}
+
+func testAppManifest(name string, ports ...types.Port) *schema.AppManifest {
+	return &schema.AppManifest{
+		Name:  types.ACName(name),
+		Exec:  []string{"/" + name},
+		Ports: ports,
+	}
+}
+
+// readUnit returns the options of the unit file at path, or nil if there is
+// none
+func readUnit(t *testing.T, path string) []*unit.UnitOption {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("error opening unit: %v", err)
+	}
+	defer f.Close()
+	opts, err := unit.Deserialize(f)
+	if err != nil {
+		t.Fatalf("error parsing unit %q: %v", path, err)
+	}
+	return opts
+}
+
+// unitValues returns the values of the given option of a unit
+func unitValues(opts []*unit.UnitOption, section, name string) []string {
+	var vals []string
+	for _, o := range opts {
+		if o.Section == section && o.Name == name {
+			vals = append(vals, o.Value)
+		}
+	}
+	return vals
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestSocketActivation(t *testing.T) {
+	apps := []schema.App{{Name: "web"}, {Name: "dns"}, {Name: "plain"}}
+	c := newTestContainer(t, apps, []*schema.AppManifest{
+		testAppManifest("web",
+			types.Port{Name: "http", Protocol: "tcp", Port: 80, SocketActivated: true},
+			types.Port{Name: "https", Protocol: "tcp", Port: 443, SocketActivated: true},
+			types.Port{Name: "admin", Protocol: "tcp", Port: 8080},
+		),
+		testAppManifest("dns",
+			types.Port{Name: "dns", Protocol: "udp", Port: 53, SocketActivated: true},
+		),
+		testAppManifest("plain",
+			types.Port{Name: "http", Protocol: "tcp", Port: 80},
+		),
+	})
+	defer os.RemoveAll(c.Root)
+
+	if err := c.ContainerToSystemd(); err != nil {
+		t.Fatalf("unexpected error generating units: %v", err)
+	}
+
+	tests := []struct {
+		app       types.ACName
+		listen    string
+		ports     []string
+		activated bool
+	}{
+		{"web", "ListenStream", []string{"80", "443"}, true},
+		{"dns", "ListenDatagram", []string{"53"}, true},
+		{"plain", "", nil, false},
+	}
+	for _, tt := range tests {
+		opts := readUnit(t, SocketFilePath(c.Root, tt.app))
+		if !tt.activated {
+			if opts != nil {
+				t.Errorf("%s: unexpected socket unit", tt.app)
+			}
+			if !exists(WantLinkPath(c.Root, tt.app)) {
+				t.Errorf("%s: service not started with the container", tt.app)
+			}
+			continue
+		}
+		if got := strings.Join(unitValues(opts, "Socket", tt.listen), " "); got != strings.Join(tt.ports, " ") {
+			t.Errorf("%s: expected %s=%v, got %q", tt.app, tt.listen, tt.ports, got)
+		}
+		// the ports not activated are not listened on by systemd
+		if n := len(unitValues(opts, "Socket", "ListenStream")) + len(unitValues(opts, "Socket", "ListenDatagram")); n != len(tt.ports) {
+			t.Errorf("%s: expected %d listening sockets, got %d", tt.app, len(tt.ports), n)
+		}
+		if got := unitValues(opts, "Socket", "Accept"); len(got) != 1 || got[0] != "false" {
+			t.Errorf("%s: expected Accept=false, got %v", tt.app, got)
+		}
+		// the app is only started by its socket
+		if !exists(SocketWantLinkPath(c.Root, tt.app)) {
+			t.Errorf("%s: socket not started with the container", tt.app)
+		}
+		if exists(WantLinkPath(c.Root, tt.app)) {
+			t.Errorf("%s: service started with the container", tt.app)
+		}
+		if readUnit(t, ServiceFilePath(c.Root, tt.app)) == nil {
+			t.Errorf("%s: no service unit", tt.app)
+		}
+	}
+}
+
+func TestSocketActivationProtocol(t *testing.T) {
+	apps := []schema.App{{Name: "sctp"}}
+	c := newTestContainer(t, apps, []*schema.AppManifest{
+		testAppManifest("sctp",
+			types.Port{Name: "sctp", Protocol: "sctp", Port: 9, SocketActivated: true},
+		),
+	})
+	defer os.RemoveAll(c.Root)
+
+	if err := c.ContainerToSystemd(); err == nil {
+		t.Errorf("expected error for socket activation of an sctp port")
+	}
+	if exists(SocketWantLinkPath(c.Root, "sctp")) {
+		t.Errorf("socket of unsupported protocol started with the container")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	apps := []schema.App{
+		{Name: "web", After: []types.AppDependency{{App: "db", Ready: true}, {App: "cache"}}},
+		{Name: "db"},
+		{Name: "cache"},
+	}
+	c := newTestContainer(t, apps, []*schema.AppManifest{
+		testAppManifest("web"), testAppManifest("db"), testAppManifest("cache"),
+	})
+	defer os.RemoveAll(c.Root)
+
+	if err := c.ContainerToSystemd(); err != nil {
+		t.Fatalf("unexpected error generating units: %v", err)
+	}
+
+	web := readUnit(t, ServiceFilePath(c.Root, "web"))
+	deps := []string{ServiceName("db"), ServiceName("cache")}
+	// the app is stopped when an app it starts after stops
+	for _, opt := range []string{"After", "BindsTo"} {
+		if got := unitValues(web, "Unit", opt); strings.Join(got, " ") != strings.Join(deps, " ") {
+			t.Errorf("web: expected %s=%v, got %v", opt, deps, got)
+		}
+	}
+	if got := unitValues(web, "Unit", "Requires"); len(got) != 0 {
+		t.Errorf("web: unexpected Requires=%v", got)
+	}
+	if got := unitValues(web, "Service", "Type"); len(got) != 0 {
+		t.Errorf("web: unexpected Type=%v", got)
+	}
+
+	// only the app started after once ready notifies its readiness, once
+	// its notification socket is there
+	db := readUnit(t, ServiceFilePath(c.Root, "db"))
+	if got := unitValues(db, "Service", "Type"); len(got) != 1 || got[0] != "notify" {
+		t.Errorf("db: expected Type=notify, got %v", got)
+	}
+	for _, opt := range []string{"After", "Requires"} {
+		if got := unitValues(db, "Unit", opt); len(got) != 1 || got[0] != NotifySocketServiceName("db") {
+			t.Errorf("db: expected %s=%s, got %v", opt, NotifySocketServiceName("db"), got)
+		}
+	}
+	cache := readUnit(t, ServiceFilePath(c.Root, "cache"))
+	if got := unitValues(cache, "Service", "Type"); len(got) != 0 {
+		t.Errorf("cache: unexpected Type=%v", got)
+	}
+	if readUnit(t, UnitFilePath(c.Root, NotifySocketServiceName("cache"))) != nil {
+		t.Errorf("cache: unexpected notification socket service")
+	}
+
+	wait := readUnit(t, UnitFilePath(c.Root, NotifySocketServiceName("db")))
+	execs := unitValues(wait, "Service", "ExecStart")
+	if len(execs) != 1 {
+		t.Fatalf("expected one ExecStart, got %v", execs)
+	}
+	argv, err := splitWords(execs[0])
+	if err != nil {
+		t.Fatalf("bad ExecStart %q: %v", execs[0], err)
+	}
+	sock := filepath.Join(rktpath.RelAppRootfsPath("db"), "/run/systemd/notify")
+	if len(argv) != 3 || argv[0] != "/usr/bin/bash" || !strings.Contains(argv[2], "'"+sock+"'") {
+		t.Errorf("unexpected ExecStart %q", argv)
+	}
+}
+
+func TestNotifySocket(t *testing.T) {
+	apps := []schema.App{
+		{Name: "web", After: []types.AppDependency{{App: "example.com/db", Ready: true}}},
+		{Name: "example.com/db"},
+	}
+	c := newTestContainer(t, apps, []*schema.AppManifest{
+		testAppManifest("web"), testAppManifest("db"),
+	})
+	defer os.RemoveAll(c.Root)
+
+	if err := c.SetupNotifyDir(nil); err != nil {
+		t.Fatalf("unexpected error setting up notify dirs: %v", err)
+	}
+	if exists(AppNotifyDirPath(c.Root, "web")) {
+		t.Errorf("notify directory created for app not notifying")
+	}
+
+	// stage1 and the app notifying its readiness get directories of their
+	// own
+	args, err := c.ContainerToNspawnArgs()
+	if err != nil {
+		t.Fatalf("unexpected error generating nspawn args: %v", err)
+	}
+	var binds []string
+	for _, a := range args {
+		if strings.HasPrefix(a, "--bind=") {
+			binds = append(binds, a)
+		}
+	}
+	sysDir, _ := filepath.Abs(SystemdRunPath(c.Root))
+	appDir, _ := filepath.Abs(AppNotifyDirPath(c.Root, "example.com/db"))
+	want := []string{
+		"--bind=" + sysDir + ":/run/systemd",
+		"--bind=" + appDir + ":" + filepath.Join(rktpath.RelAppRootfsPath("example.com/db"), "/run/systemd"),
+	}
+	if strings.Join(binds, " ") != strings.Join(want, " ") {
+		t.Errorf("expected binds %v, got %v", want, binds)
+	}
+
+	// the socket systemd creates is linked into the directory of the app,
+	// which holds nothing else
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.LinkNotifySocket(stop)
+		close(done)
+	}()
+	l, err := net.Listen("unix", filepath.Join(sysDir, "notify"))
+	if err != nil {
+		t.Fatalf("error creating socket: %v", err)
+	}
+	defer l.Close()
+	if err := ioutil.WriteFile(filepath.Join(sysDir, "private"), nil, 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(stop)
+		t.Fatalf("socket not linked")
+	}
+	fis, err := ioutil.ReadDir(appDir)
+	if err != nil {
+		t.Fatalf("error reading app notify dir: %v", err)
+	}
+	if len(fis) != 1 || fis[0].Name() != "notify" || fis[0].Mode()&os.ModeSocket == 0 {
+		t.Errorf("expected only the notification socket in the app directory, got %v", fis)
+	}
+}
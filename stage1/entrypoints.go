@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/common"
+	"github.com/coreos/rocket/stage1/networking"
+)
+
+// stage1Systemctl is the path of systemctl(1) in the stage1 rootfs
+const stage1Systemctl = "/usr/bin/systemctl"
+
+// stopEntry asks the running container to stop: the reaper stops the apps,
+// records their exit statuses and halts the container
+func stopEntry(args []string) int {
+	common.EntryFlags("stop", &debug, &uuid).Parse(args)
+
+	c, err := common.LoadEntryContainer(uuid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := systemctl(c.Root, "--no-block", "isolate", "reaper.service"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to stop apps: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// killEntry sends a signal to the main process of the given apps, or of all
+// the apps, of the running container
+func killEntry(args []string) int {
+	var (
+		sig  int
+		apps stringList
+	)
+	fs := common.EntryFlags("kill", &debug, &uuid)
+	fs.IntVar(&sig, "signal", 15, "Signal to send, by number")
+	fs.Var(&apps, "app", "App to send the signal to (can be repeated)")
+	fs.Parse(args)
+
+	c, err := common.LoadEntryContainer(uuid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(apps) == 0 {
+		for _, a := range c.Manifest.Apps {
+			apps = append(apps, a.Name.String())
+		}
+	}
+	sargs := []string{"kill", "--kill-who=main", "--signal=" + strconv.Itoa(sig)}
+	for _, a := range apps {
+		if c.Manifest.Apps.Get(types.ACName(a)) == nil {
+			fmt.Fprintf(os.Stderr, "No app %q in container\n", a)
+			return 1
+		}
+		sargs = append(sargs, ServiceName(types.ACName(a)))
+	}
+	if err := systemctl(c.Root, sargs...); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to signal apps: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// gcEntry cleans up after the exited container: its registration with the
+// metadata service is removed and its private network torn down if the
+// container did not get to do it (e.g. it was killed)
+func gcEntry(args []string) int {
+	fs := common.EntryFlags("gc", &debug, &uuid)
+	fs.StringVar(&netDir, "net-dir", "", "Directory holding network state")
+	fs.Parse(args)
+
+	c, err := common.LoadEntryContainer(uuid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if err := common.UnregisterContainer(c.Manifest.UUID); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to unregister from the metadata service: %v\n", err)
+		return 1
+	}
+	if _, err := os.Stat(path.NetInfoPath(c.Root)); os.IsNotExist(err) {
+		return 0
+	}
+	// the stage1 rootfs of the container may have been written to by the
+	// apps: the plugins come from the rootfs this entrypoint runs from
+	self, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find stage1 rootfs: %v\n", err)
+		return 1
+	}
+	pluginDirs := []string{
+		filepath.Join(filepath.Dir(self), networking.PluginsDir),
+		networking.PluginsDir,
+	}
+	n, err := networking.Load(c.Root, netDir, pluginDirs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load network: %v\n", err)
+		return 1
+	}
+	if !n.Active() {
+		return 0
+	}
+	if err := n.Teardown(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to teardown network: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// systemctl runs the systemctl of stage1 with the given arguments, in the
+// running container in root
+func systemctl(root string, args ...string) error {
+	ipid, err := common.InitPid(root)
+	if err != nil {
+		return err
+	}
+	nargs, err := common.NsenterArgs(root, ipid, "/")
+	if err != nil {
+		return err
+	}
+	nargs = append(nargs, stage1Systemctl)
+	nargs = append(nargs, args...)
+	if b, err := exec.Command(nargs[0], nargs[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(b))
+	}
+	return nil
+}
+
+// stringList implements the flag.Value interface to contain repeated flags
+type stringList []string
+
+func (sl *stringList) Set(s string) error {
+	*sl = append(*sl, s)
+	return nil
+}
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
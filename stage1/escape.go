@@ -0,0 +1,74 @@
+package main
+
+//
+// Escaping of values written into systemd unit files
+//
+
+import (
+	"fmt"
+	"strings"
+)
+
+// quoteExec renders argv as the value of an Exec*= unit setting, such that
+// systemd passes it to the executed program verbatim. Every argument is
+// double quoted, with the C-style escapes systemd understands for backslashes,
+// quotes and control characters, while specifiers ("%") and variable
+// expansion ("$") are suppressed by doubling.
+func quoteExec(argv []string) string {
+	qargs := make([]string, len(argv))
+	for i, arg := range argv {
+		qargs[i] = quote(arg, true)
+	}
+	return strings.Join(qargs, " ")
+}
+
+// quoteEnv renders the variable key with value val as the value of an
+// Environment= unit setting. An error is returned if key is not a valid
+// environment variable name.
+func quoteEnv(key, val string) (string, error) {
+	if !validEnvName(key) {
+		return "", fmt.Errorf("invalid environment variable name %q", key)
+	}
+	// systemd does not expand variables in Environment=, so "$" is kept
+	return quote(key+"="+val, false), nil
+}
+
+func quote(s string, escapeDollar bool) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' || c == '"' || c == '\'':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '\t':
+			b = append(b, '\\', 't')
+		case c < ' ' || c == 0x7f:
+			b = append(b, fmt.Sprintf(`\x%02x`, c)...)
+		case c == '%':
+			b = append(b, '%', '%')
+		case c == '$' && escapeDollar:
+			b = append(b, '$', '$')
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
+
+func validEnvName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
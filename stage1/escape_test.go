@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// resolveSpecifiers mimics systemd's handling of specifiers in unit settings,
+// which happens before the value is split. Only "%%" is expected, since the
+// quoting functions never emit any other specifier.
+func resolveSpecifiers(s string) (string, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+1 >= len(s) || s[i+1] != '%' {
+			return "", fmt.Errorf("specifier would be expanded at %d in %q", i, s)
+		}
+		b = append(b, '%')
+		i++
+	}
+	return string(b), nil
+}
+
+// splitWords mimics systemd's splitting of a setting into words, honouring
+// quotes and C-style escapes.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	for i := 0; i < len(s); {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i == len(s) {
+			break
+		}
+		var w []byte
+		var quote byte
+		for ; i < len(s); i++ {
+			c := s[i]
+			if quote == 0 && (c == ' ' || c == '\t') {
+				break
+			}
+			switch {
+			case c == '\\':
+				if i+1 >= len(s) {
+					return nil, errors.New("trailing backslash")
+				}
+				i++
+				switch s[i] {
+				case '\\', '"', '\'':
+					w = append(w, s[i])
+				case 'n':
+					w = append(w, '\n')
+				case 't':
+					w = append(w, '\t')
+				case 'x':
+					if i+2 >= len(s) {
+						return nil, errors.New("short \\x escape")
+					}
+					n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+					if err != nil {
+						return nil, err
+					}
+					w = append(w, byte(n))
+					i += 2
+				default:
+					return nil, fmt.Errorf("unknown escape \\%c", s[i])
+				}
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+			case c == quote:
+				quote = 0
+			default:
+				w = append(w, c)
+			}
+		}
+		if quote != 0 {
+			return nil, errors.New("unterminated quote")
+		}
+		words = append(words, string(w))
+	}
+	return words, nil
+}
+
+// expandVariables mimics systemd's variable expansion in Exec*= arguments
+// with an empty environment: any variable reference is an error here, as
+// quoteExec is expected to only leave "$$" behind.
+func expandVariables(s string) (string, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+1 >= len(s) || s[i+1] != '$' {
+			return "", fmt.Errorf("variable would be expanded at %d in %q", i, s)
+		}
+		b = append(b, '$')
+		i++
+	}
+	return string(b), nil
+}
+
+func parseExec(s string) ([]string, error) {
+	s, err := resolveSpecifiers(s)
+	if err != nil {
+		return nil, err
+	}
+	words, err := splitWords(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, w := range words {
+		if words[i], err = expandVariables(w); err != nil {
+			return nil, err
+		}
+	}
+	return words, nil
+}
+
+func parseEnv(s string) ([]string, error) {
+	s, err := resolveSpecifiers(s)
+	if err != nil {
+		return nil, err
+	}
+	return splitWords(s)
+}
+
+var hostileArgs = [][]string{
+	{"/bin/true"},
+	{"/bin/echo", "hello world"},
+	{"/bin/echo", ""},
+	{"/bin/echo", "", "", "x"},
+	{"/bin/echo", `"double"`, `'single'`, `mixed"'quotes`},
+	{"/bin/echo", "$HOME", "${PATH}", "$$", "a$", "$"},
+	{"/bin/echo", "%n", "%%", "100%", "%"},
+	{"/bin/echo", `back\slash`, `\`, `\\`, `\n`, `\x41`, `trailing\`},
+	{"/bin/echo", "new\nline", "tab\there", "cr\rret", "bell\a", "nul-free\x7f"},
+	{"/bin/echo", ";", "a;b", "&&", "|", ">", "`id`", "$(id)"},
+	{"/bin/echo", "  leading", "trailing  ", " ", "\t"},
+	{"/bin/echo", "ünïcödé", "日本語", "emoji 🚀"},
+	{"/bin/sh", "-c", `echo "$1" '%s' \; exit 0`, "--", `it's "quoted"`},
+	{"/path with spaces/bin", "-", "@", "+", "!"},
+}
+
+func TestQuoteExecRoundTrip(t *testing.T) {
+	for i, argv := range hostileArgs {
+		q := quoteExec(argv)
+		if strings.ContainsAny(q, "\n\r") {
+			t.Errorf("#%d: quoted Exec %q spans multiple lines", i, q)
+		}
+		got, err := parseExec(q)
+		if err != nil {
+			t.Errorf("#%d: error parsing %q: %v", i, q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, argv) {
+			t.Errorf("#%d: %q round-tripped to %q (quoted: %s)", i, argv, got, q)
+		}
+	}
+}
+
+func TestQuoteExec(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{
+			[]string{"/bin/true"},
+			`"/bin/true"`,
+		},
+		{
+			[]string{"/bin/echo", "a b", ""},
+			`"/bin/echo" "a b" ""`,
+		},
+		{
+			[]string{"/bin/echo", `"$HOME" 50%`},
+			`"/bin/echo" "\"$$HOME\" 50%%"`,
+		},
+		{
+			[]string{"/bin/echo", "it's\\\n"},
+			`"/bin/echo" "it\'s\\\n"`,
+		},
+		{
+			[]string{"/bin/echo", "\x01"},
+			`"/bin/echo" "\x01"`,
+		},
+	}
+	for i, tt := range tests {
+		if got := quoteExec(tt.in); got != tt.want {
+			t.Errorf("#%d: got %s, want %s", i, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteEnv(t *testing.T) {
+	tests := []struct {
+		key  string
+		val  string
+		want string
+	}{
+		{"FOO", "bar", `"FOO=bar"`},
+		{"FOO", "", `"FOO="`},
+		{"_X1", "a b", `"_X1=a b"`},
+		{"PRICE", "$5 or 10%", `"PRICE=$5 or 10%%"`},
+		{"Q", `say "hi"` + "\n", `"Q=say \"hi\"\n"`},
+		{"EQ", "a=b=c", `"EQ=a=b=c"`},
+	}
+	for i, tt := range tests {
+		got, err := quoteEnv(tt.key, tt.val)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("#%d: got %s, want %s", i, got, tt.want)
+		}
+		words, err := parseEnv(got)
+		if err != nil {
+			t.Errorf("#%d: error parsing %q: %v", i, got, err)
+			continue
+		}
+		if want := []string{tt.key + "=" + tt.val}; !reflect.DeepEqual(words, want) {
+			t.Errorf("#%d: %q round-tripped to %q", i, want, words)
+		}
+	}
+}
+
+func TestQuoteEnvBadName(t *testing.T) {
+	for _, key := range []string{"", "1FOO", "FOO BAR", "FOO=BAR", "FOO-BAR", "FÖÖ", `"FOO"`} {
+		if _, err := quoteEnv(key, "val"); err == nil {
+			t.Errorf("expected error for environment variable name %q", key)
+		}
+	}
+}
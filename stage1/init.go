@@ -1,14 +1,25 @@
 package main
 
-// this implements /init of stage1/host_nspawn-systemd
+// this implements /init of stage1/host_nspawn-systemd, providing the
+// entrypoints of the stage1 interface (cf. Documentation/stage1-interface.md)
+// as subcommands: run, enter, stop, kill and gc. The app and log subcommands
+// are run by the systemd of stage1, for the commands of the apps.
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
 
 	"github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/pkg/uid"
+	"github.com/coreos/rocket/stage1/common"
+	"github.com/coreos/rocket/stage1/networking"
 )
 
 const (
@@ -16,19 +27,63 @@ const (
 	nspawnBin = "/usr/bin/systemd-nspawn"
 )
 
+var (
+	runFlags = flag.NewFlagSet("run", flag.ExitOnError)
+
+	debug      bool
+	uuid       string
+	privateNet bool
+	netDir     string
+	ports      portRequests
+	// uid range the user namespace of the container is mapped to, if any
+	privateUsers string
+	// where the logs of the apps are kept: "files" or "journal"
+	logTarget string
+)
+
+func init() {
+	runFlags.BoolVar(&debug, "debug", false, "Run in debug mode")
+	runFlags.StringVar(&uuid, "uuid", "", "UUID of the container")
+	runFlags.BoolVar(&privateNet, "private-net", false, "Setup private network")
+	runFlags.StringVar(&netDir, "net-dir", "", "Directory holding network state")
+	runFlags.Var(&ports, "port", "Publish the named app port on the given host port")
+	runFlags.StringVar(&privateUsers, "private-users", "", "Run in a user namespace mapped to the given uid range (BASE:COUNT)")
+	runFlags.StringVar(&logTarget, "log-target", "files", "Keep the logs of the apps in journal files in the container directory (files) or in the host journal (journal)")
+}
+
+// entrypoints are the subcommands of the init, by name
+var entrypoints = map[string]func(args []string) int{
+	"run":   runEntry,
+	"enter": common.EnterEntry,
+	"stop":  stopEntry,
+	"kill":  killEntry,
+	"gc":    gcEntry,
+	// run by the systemd of stage1, for the commands of the apps
+	"app": appEntry,
+	"log": logEntry,
+}
+
 func main() {
+	if len(os.Args) < 2 || entrypoints[os.Args[1]] == nil {
+		fmt.Fprintln(os.Stderr, "Usage: init run|enter|stop|kill|gc [FLAGS]")
+		os.Exit(1)
+	}
+	os.Exit(entrypoints[os.Args[1]](os.Args[2:]))
+}
+
+// runEntry runs the container, and returns the exit status of its main app
+func runEntry(flags []string) int {
+	runFlags.Parse(flags)
 	root := "."
-	debug := len(os.Args) > 1 && os.Args[1] == "debug"
 
 	c, err := LoadContainer(root)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load container: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
-
-	if err = c.ContainerToSystemd(); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to configure systemd: %v\n", err)
-		os.Exit(2)
+	if err := (*common.Container)(c).CheckUUID(uuid); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
 	}
 
 	// TODO(philips): compile a static version of systemd-nspawn with this
@@ -41,7 +96,7 @@ func main() {
 	ex := filepath.Join(path.Stage1RootfsPath(c.Root), nspawnBin)
 	if _, err := os.Stat(ex); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed locating nspawn: %v\n", err)
-		os.Exit(3)
+		return 3
 	}
 
 	args := []string{
@@ -54,16 +109,54 @@ func main() {
 		args = append(args, "--quiet") // silence most nspawn output (log_warning is currently not covered by this)
 	}
 
+	var ur *uid.Range
+	if privateUsers != "" {
+		ur, err = uid.ParseRange(privateUsers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad private users range: %v\n", err)
+			return 4
+		}
+		args = append(args, "--private-users="+ur.String())
+	}
+
+	fps, err := c.resolvePorts(ports)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve ports: %v\n", err)
+		return 4
+	}
+	if len(fps) > 0 && !privateNet {
+		fmt.Fprintln(os.Stderr, "Publishing ports requires a private network")
+		return 4
+	}
+
+	switch logTarget {
+	case "files":
+	case "journal":
+		// the journal of the container is kept in the one of the host
+		args = append(args, "--link-journal=host")
+	default:
+		fmt.Fprintf(os.Stderr, "Bad log target %q\n", logTarget)
+		return 4
+	}
+	if err := c.SetupJournal(logTarget == "files", ur); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to setup journal: %v\n", err)
+		return 4
+	}
+
+	if err := c.SetupNotifyDir(ur); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to setup notify directory: %v\n", err)
+		return 4
+	}
 	nsargs, err := c.ContainerToNspawnArgs()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to generate nspawn args: %v\n", err)
-		os.Exit(4)
+		return 4
 	}
 	args = append(args, nsargs...)
 
 	// Arguments to systemd
 	args = append(args, "--")
-	args = append(args, "--default-standard-output=tty") // redirect all service logs straight to tty
+	args = append(args, "--default-standard-output=journal+console") // log all service output, and show it on the tty
 	if !debug {
 		args = append(args, "--log-target=null") // silence systemd output inside container
 		args = append(args, "--show-status=0")   // silence systemd initialization status output
@@ -71,8 +164,145 @@ func main() {
 
 	env := os.Environ()
 
-	if err := syscall.Exec(ex, args, env); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to execute nspawn: %v\n", err)
-		os.Exit(5)
+	// nspawn is run as a child rather than exec()ed so that the network
+	// can be torn down and the exit status of the apps collected once the
+	// container exits
+	var n *networking.Networking
+	if privateNet {
+		pluginDirs := []string{
+			filepath.Join(path.Stage1RootfsPath(c.Root), networking.PluginsDir),
+			networking.PluginsDir,
+		}
+		n, err = networking.Setup(root, c.Manifest.UUID, netDir, pluginDirs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to setup network: %v\n", err)
+			return 6
+		}
+		if err := n.ForwardPorts(fps); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to forward ports: %v\n", err)
+			n.Teardown()
+			return 6
+		}
+		if err := registerContainer(c, n); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to register with the metadata service: %v\n", err)
+			n.Teardown()
+			return 6
+		}
+	}
+
+	var status int
+	// the units are generated once the container is registered, for the
+	// apps to be told about the metadata service
+	if err = c.ContainerToSystemd(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure systemd: %v\n", err)
+		status = 2
+	} else {
+		status, err = runNspawn(c, n, ex, args, env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to execute nspawn: %v\n", err)
+			status = 5
+		}
+	}
+	if c.MetadataURL != "" {
+		if err := common.UnregisterContainer(c.Manifest.UUID); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to unregister from the metadata service: %v\n", err)
+		}
+	}
+	if n != nil {
+		if err := n.Teardown(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to teardown network: %v\n", err)
+		}
+	}
+	if err == nil {
+		if s, err := (*common.Container)(c).MainAppStatus(); err == nil {
+			status = s
+		} else if status == 0 {
+			fmt.Fprintf(os.Stderr, "Failed to get exit status of main app: %v\n", err)
+			status = 7
+		}
+	}
+	return status
+}
+
+// registerContainer registers the container with the metadata service, if it
+// runs on the host and the container can reach it through the host side
+// interface of its first network (i.e. the network is bridged)
+func registerContainer(c *Container, n *networking.Networking) error {
+	if !common.MetadataSvcAvailable() {
+		return nil
+	}
+	res := &n.Nets[0].Result
+	if res.HostIf == "" {
+		fmt.Fprintf(os.Stderr, "Warning: network %q has no host interface, the metadata service is not available to the container\n", n.Nets[0].Name)
+		return nil
+	}
+	ipn, err := res.IPNet()
+	if err != nil {
+		return err
+	}
+	if err := (*common.Container)(c).RegisterContainer(ipn.IP, res.HostIf); err != nil {
+		common.UnregisterContainer(c.Manifest.UUID)
+		return err
+	}
+	c.MetadataURL = common.MetadataSvcURL
+	return nil
+}
+
+// runNspawn runs nspawn as a child, inside the network namespace of n if n
+// is not nil, forwarding termination signals to it and linking the
+// notification socket of systemd for the apps. The PID of nspawn is
+// recorded in the container directory while it runs. The exit status of
+// nspawn is returned.
+func runNspawn(c *Container, n *networking.Networking, bin string, args []string, env []string) (int, error) {
+	cmd := &exec.Cmd{
+		Path:   bin,
+		Args:   args,
+		Env:    env,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	var err error
+	if n != nil {
+		err = n.Run(cmd.Start)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	pidPath := path.PidPath(c.Root)
+	if err := ioutil.WriteFile(pidPath, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("failed to write pid file: %v", err)
+	}
+	defer os.Remove(pidPath)
+
+	// the apps notifying their readiness wait for the socket of systemd
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.LinkNotifySocket(stop)
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigc {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	signal.Stop(sigc)
+	close(sigc)
+	if err == nil {
+		return 0, nil
+	}
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			return ws.ExitStatus(), nil
+		}
 	}
+	return 0, err
 }
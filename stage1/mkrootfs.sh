@@ -1,7 +1,8 @@
 #!/bin/bash -e
 
 # Derive a minimal rootfs for hosting systemd from a coreos release pxe image
-# This is only done when we need to update ../cmd/s1rootfs.go
+# This is only done when we need to update the rootfs tarball of the stage1
+# image (cf. ../build)
 
 URL="http://stable.release.core-os.net/amd64-usr/current/coreos_production_pxe_image.cpio.gz"
 
@@ -17,16 +18,14 @@ req gzip
 req cpio
 req install
 req tar
-req go-bindata
 
 # extract the squashfs from the cpio then use unsquashfs to extract the required files for systemd
 WORK="mkroot"
 USRFS="usr.squashfs"
 ROOTDIR="${WORK}/rootfs"
-BINDIR="${WORK}/bins"
 USR="rootfs/usr"
 FILELIST="filelist.txt"
-OUTPUT=${OUTPUT:="../stage0/stage1_rootfs/bin.go"}
+OUTPUT=${OUTPUT:="../bin/stage1-rootfs.tar"}
 
 [ -e "${WORK}" ] && rm -Rf "${WORK}"
 
@@ -242,9 +241,7 @@ install -d "${ROOTDIR}/opt/stage2"
 install -d "${ROOTDIR}/rkt/status"
 
 # fin
-mkdir "${BINDIR}"
-tar cf "${BINDIR}/s1rootfs.tar" -C "${ROOTDIR}" .
 OUTDIR=$(dirname "${OUTPUT}")
 [ -d "$OUTDIR" ] || mkdir -p "${OUTDIR}"
-go-bindata -o "${OUTPUT}" -prefix $(realpath "${BINDIR}") -pkg=stage1_rootfs "${BINDIR}"
+tar cf "${OUTPUT}" -C "${ROOTDIR}" .
 rm -Rf "${WORK}"
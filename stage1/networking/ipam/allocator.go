@@ -0,0 +1,158 @@
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+var ErrNoAvailableIP = errors.New("no IP addresses available in subnet")
+
+// IPAllocator hands out addresses from a subnet, recording each lease as a
+// file (named after the address, containing the ID of the lease holder) in
+// a directory so that allocations survive across invocations of rkt.
+type IPAllocator struct {
+	dir     string
+	subnet  *net.IPNet
+	gateway net.IP
+}
+
+// NewIPAllocator returns an IPAllocator for subnet which keeps its leases in
+// dir, creating dir if necessary. The gateway address is never handed out;
+// if gw is nil, the first address in the subnet is used as the gateway.
+func NewIPAllocator(dir string, subnet *net.IPNet, gw net.IP) (*IPAllocator, error) {
+	if subnet.IP.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 subnets are supported: %v", subnet)
+	}
+	if gw == nil {
+		gw = nextIP(subnet.IP.Mask(subnet.Mask))
+	} else if !subnet.Contains(gw) {
+		return nil, fmt.Errorf("gateway %v is not in subnet %v", gw, subnet)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating lease directory: %v", err)
+	}
+	return &IPAllocator{dir, subnet, gw.To4()}, nil
+}
+
+// NewReleaser returns an IPAllocator that can only be used to release
+// leases kept in dir.
+func NewReleaser(dir string) *IPAllocator {
+	return &IPAllocator{dir: dir}
+}
+
+// Gateway returns the address reserved for the host side of the subnet
+func (a *IPAllocator) Gateway() net.IP {
+	return a.gateway
+}
+
+// Allocate leases a free address in the subnet to id.
+func (a *IPAllocator) Allocate(id string) (net.IP, error) {
+	unlock, err := a.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	first := nextIP(a.subnet.IP.Mask(a.subnet.Mask))
+	for ip := first; a.subnet.Contains(ip); ip = nextIP(ip) {
+		if isBroadcast(ip, a.subnet) {
+			break
+		}
+		if ip.Equal(a.gateway) {
+			continue
+		}
+		f, err := os.OpenFile(a.leasePath(ip), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		switch {
+		case err == nil:
+		case os.IsExist(err):
+			continue
+		default:
+			return nil, fmt.Errorf("error creating lease for %v: %v", ip, err)
+		}
+		_, err = f.WriteString(id)
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+			return nil, fmt.Errorf("error writing lease for %v: %v", ip, err)
+		}
+		return ip, nil
+	}
+	return nil, ErrNoAvailableIP
+}
+
+// Release frees all the addresses leased to id.
+func (a *IPAllocator) Release(id string) error {
+	unlock, err := a.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fis, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		return fmt.Errorf("error reading lease directory: %v", err)
+	}
+	for _, fi := range fis {
+		if net.ParseIP(fi.Name()) == nil {
+			continue
+		}
+		p := filepath.Join(a.dir, fi.Name())
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("error reading lease %q: %v", fi.Name(), err)
+		}
+		if strings.TrimSpace(string(b)) == id {
+			if err := os.Remove(p); err != nil {
+				return fmt.Errorf("error removing lease %q: %v", fi.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *IPAllocator) leasePath(ip net.IP) string {
+	return filepath.Join(a.dir, ip.String())
+}
+
+// lock takes an exclusive lock on the lease directory, returning a function
+// which releases it.
+func (a *IPAllocator) lock() (func(), error) {
+	f, err := os.Open(a.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lease directory: %v", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error locking lease directory: %v", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	ip4 := ip.To4()
+	next := make(net.IP, len(ip4))
+	copy(next, ip4)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+func isBroadcast(ip net.IP, subnet *net.IPNet) bool {
+	ip4 := ip.To4()
+	for i := range ip4 {
+		if ip4[i]|subnet.Mask[len(subnet.Mask)-len(ip4)+i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestIPAllocator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-ipam")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, subnet, _ := net.ParseCIDR("10.1.2.0/29")
+	ipa, err := NewIPAllocator(dir, subnet, nil)
+	if err != nil {
+		t.Fatalf("error creating allocator: %v", err)
+	}
+	if gw := ipa.Gateway().String(); gw != "10.1.2.1" {
+		t.Errorf("gateway: got %s, want 10.1.2.1", gw)
+	}
+
+	// .2 to .6 are usable; .1 is the gateway and .7 the broadcast address
+	ids := []string{"a", "b", "c", "d", "e"}
+	for i, want := range []string{"10.1.2.2", "10.1.2.3", "10.1.2.4", "10.1.2.5", "10.1.2.6"} {
+		ip, err := ipa.Allocate(ids[i])
+		if err != nil {
+			t.Fatalf("unexpected error allocating %s: %v", want, err)
+		}
+		if ip.String() != want {
+			t.Errorf("got %s, want %s", ip, want)
+		}
+	}
+	if _, err := ipa.Allocate("f"); err != ErrNoAvailableIP {
+		t.Errorf("expected ErrNoAvailableIP from exhausted subnet, got %v", err)
+	}
+
+	if err := ipa.Release("b"); err != nil {
+		t.Fatalf("unexpected error releasing lease: %v", err)
+	}
+	ip, err := ipa.Allocate("g")
+	if err != nil {
+		t.Fatalf("unexpected error reallocating: %v", err)
+	}
+	if ip.String() != "10.1.2.3" {
+		t.Errorf("got %s, want released address 10.1.2.3", ip)
+	}
+
+	// a new allocator over the same directory must see existing leases
+	ipa, err = NewIPAllocator(dir, subnet, nil)
+	if err != nil {
+		t.Fatalf("error recreating allocator: %v", err)
+	}
+	if _, err := ipa.Allocate("h"); err != ErrNoAvailableIP {
+		t.Errorf("expected leases to persist, got %v", err)
+	}
+}
+
+func TestIPAllocatorGateway(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-ipam")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, subnet, _ := net.ParseCIDR("10.1.2.0/30")
+	if _, err := NewIPAllocator(dir, subnet, net.ParseIP("10.1.3.1")); err == nil {
+		t.Errorf("expected error for gateway outside of subnet")
+	}
+
+	// with .2 as the gateway, .1 is the only usable address
+	ipa, err := NewIPAllocator(dir, subnet, net.ParseIP("10.1.2.2"))
+	if err != nil {
+		t.Fatalf("error creating allocator: %v", err)
+	}
+	ip, err := ipa.Allocate("a")
+	if err != nil {
+		t.Fatalf("unexpected error allocating: %v", err)
+	}
+	if ip.String() != "10.1.2.1" {
+		t.Errorf("got %s, want 10.1.2.1", ip)
+	}
+	if _, err := ipa.Allocate("b"); err != ErrNoAvailableIP {
+		t.Errorf("expected ErrNoAvailableIP, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package networking
+
+//
+// Private networking for containers: every container gets its own network
+// namespace, which is attached to one or more networks by executing network
+// plugins (see the plugin package) as described by the configuration files
+// in netConfDir.
+//
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	rktpath "github.com/coreos/rocket/path"
+	"github.com/coreos/rocket/stage1/networking/plugin"
+	"github.com/coreos/rocket/stage1/networking/util"
+)
+
+const (
+	// netConfDir holds the network configurations, one per *.conf file
+	netConfDir = "/etc/rkt/net.d"
+	// PluginsDir is where network plugins are looked up, both in the stage1
+	// rootfs and on the host
+	PluginsDir = "/usr/lib/rkt/plugins/net"
+
+	netnsRunDir = "/var/run/netns"
+)
+
+// defaultNet is used when no network has been configured in netConfDir
+var defaultNet = []byte(`{
+	"name": "default",
+	"type": "bridge",
+	"bridge": "rkt0",
+	"isGateway": true,
+	"ipMasq": true,
+	"ipam": {
+		"type": "host-local",
+		"subnet": "172.16.28.0/24"
+	}
+}`)
+
+// activeNet is a network the container has been attached to
+type activeNet struct {
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	IfName string        `json:"ifName"`
+	Result plugin.Result `json:"result"`
+	// Conf is the configuration of the network, kept for the plugin to
+	// detach the container from it
+	Conf json.RawMessage `json:"conf"`
+}
+
+// Networking describes the private network of a container. It is serialized
+// into the container directory so that other parts of rkt (e.g. the
+// metadata service registration) can find out how the container is
+// reachable.
+type Networking struct {
+	ContainerID types.UUID  `json:"containerID"`
+	NetNS       string      `json:"netns"`
+	Nets        []activeNet `json:"nets"`
+
+	pluginPath string
+	dataDir    string
+	forwarding bool
+}
+
+// Setup creates a network namespace for the container rooted at root and
+// attaches it to all configured networks, looking up plugins in pluginDirs
+// and letting them keep their state under netDir. The resulting
+// configuration is written into the container directory.
+func Setup(root string, cuuid types.UUID, netDir string, pluginDirs []string) (*Networking, error) {
+	confs, err := loadNetConfs()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Networking{
+		ContainerID: cuuid,
+		NetNS:       filepath.Join(netnsRunDir, nsName(cuuid)),
+		pluginPath:  strings.Join(pluginDirs, string(filepath.ListSeparator)),
+		dataDir:     netDir,
+	}
+
+	if err := util.IP("netns", "add", nsName(cuuid)); err != nil {
+		return nil, fmt.Errorf("error creating network namespace: %v", err)
+	}
+
+	for i, conf := range confs {
+		nc := plugin.NetConf{}
+		if err := json.Unmarshal(conf, &nc); err != nil {
+			n.Teardown()
+			return nil, fmt.Errorf("error decoding network configuration: %v", err)
+		}
+		an := activeNet{
+			Name:   nc.Name,
+			Type:   nc.Type,
+			IfName: fmt.Sprintf("eth%d", i),
+			Conf:   conf,
+		}
+		res, err := plugin.Exec(an.Type, n.pluginArgs(plugin.CmdAdd, &an))
+		if err != nil {
+			// the plugin may have given up halfway, or not cleaned up
+			// after itself; DEL is harmless on what is not there
+			plugin.Exec(an.Type, n.pluginArgs(plugin.CmdDel, &an))
+			n.Teardown()
+			return nil, fmt.Errorf("error adding network %q: %v", an.Name, err)
+		}
+		an.Result = *res
+		n.Nets = append(n.Nets, an)
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		n.Teardown()
+		return nil, fmt.Errorf("error marshalling network info: %v", err)
+	}
+	if err := ioutil.WriteFile(rktpath.NetInfoPath(root), b, 0644); err != nil {
+		n.Teardown()
+		return nil, fmt.Errorf("error writing network info: %v", err)
+	}
+
+	return n, nil
+}
+
+// Load loads the private network of the container rooted at root, as set up
+// by Setup in another process, looking up plugins in pluginDirs and letting
+// them keep their state under netDir
+func Load(root string, netDir string, pluginDirs []string) (*Networking, error) {
+	b, err := ioutil.ReadFile(rktpath.NetInfoPath(root))
+	if err != nil {
+		return nil, fmt.Errorf("error reading network info: %v", err)
+	}
+	n := &Networking{
+		pluginPath: strings.Join(pluginDirs, string(filepath.ListSeparator)),
+		dataDir:    netDir,
+	}
+	if err := json.Unmarshal(b, n); err != nil {
+		return nil, fmt.Errorf("error decoding network info: %v", err)
+	}
+	// ports may have been forwarded since the network info was written
+	n.forwarding = iptables("-n", "-L", n.portFwdChain()) == nil
+	return n, nil
+}
+
+// Active reports whether the network namespace of the container still
+// exists, i.e. whether the network has not been torn down
+func (n *Networking) Active() bool {
+	_, err := os.Stat(n.NetNS)
+	return err == nil
+}
+
+// Teardown detaches the container from its networks, in the reverse order
+// they were attached, and destroys its network namespace.
+func (n *Networking) Teardown() error {
+	var errs []string
+	if err := n.unforwardPorts(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for i := len(n.Nets) - 1; i >= 0; i-- {
+		an := &n.Nets[i]
+		if _, err := plugin.Exec(an.Type, n.pluginArgs(plugin.CmdDel, an)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	n.Nets = nil
+
+	if _, err := os.Stat(n.NetNS); err == nil {
+		if err := util.IP("netns", "del", nsName(n.ContainerID)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error tearing down network: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Run calls f with the calling thread switched into the container's network
+// namespace, switching back once f returns. Processes started by f inherit
+// the container's network namespace.
+func (n *Networking) Run(f func() error) error {
+	return util.WithNetNS(n.NetNS, f)
+}
+
+func (n *Networking) pluginArgs(cmd string, an *activeNet) *plugin.Args {
+	return &plugin.Args{
+		Command:   cmd,
+		ContID:    n.ContainerID.String(),
+		NetNS:     n.NetNS,
+		IfName:    an.IfName,
+		Path:      n.pluginPath,
+		DataDir:   n.dataDir,
+		StdinData: an.Conf,
+	}
+}
+
+// loadNetConfs reads the network configurations in netConfDir, ordered by
+// file name, falling back to defaultNet if there are none
+func loadNetConfs() ([][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(netConfDir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return [][]byte{defaultNet}, nil
+	}
+	sort.Strings(files)
+
+	var confs [][]byte
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading network configuration: %v", err)
+		}
+		confs = append(confs, b)
+	}
+	return confs, nil
+}
+
+func nsName(cuuid types.UUID) string {
+	return "rkt-" + cuuid.String()
+}
@@ -0,0 +1,220 @@
+package plugin
+
+//
+// Network plugins are executables invoked by stage1 to attach a container to
+// a network. A plugin is passed the network configuration (JSON, see NetConf)
+// on stdin, and the following environment variables:
+//
+//   RKT_NETPLUGIN_COMMAND  ADD or DEL
+//   RKT_NETPLUGIN_CONTID   UUID of the container
+//   RKT_NETPLUGIN_NETNS    path to the network namespace of the container
+//   RKT_NETPLUGIN_IFNAME   name of the interface to create in the container
+//   RKT_NETPLUGIN_PATH     colon separated list of directories to look for
+//                          (e.g. IPAM) plugins in
+//   RKT_NETPLUGIN_DATADIR  directory in which plugins may keep state
+//
+// On ADD, a plugin writes a Result (JSON) to stdout. Errors are reported
+// through a non-zero exit status, with the cause written to stderr.
+//
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CmdAdd = "ADD"
+	CmdDel = "DEL"
+
+	EnvCommand = "RKT_NETPLUGIN_COMMAND"
+	EnvContID  = "RKT_NETPLUGIN_CONTID"
+	EnvNetNS   = "RKT_NETPLUGIN_NETNS"
+	EnvIfName  = "RKT_NETPLUGIN_IFNAME"
+	EnvPath    = "RKT_NETPLUGIN_PATH"
+	EnvDataDir = "RKT_NETPLUGIN_DATADIR"
+)
+
+// NetConf contains the fields of a network configuration common to all
+// plugins. Plugins are free to define additional fields.
+type NetConf struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	IPAM struct {
+		Type string `json:"type"`
+	} `json:"ipam"`
+}
+
+// Args describes an invocation of a plugin
+type Args struct {
+	Command string
+	ContID  string
+	NetNS   string
+	IfName  string
+	Path    string
+	DataDir string
+	// StdinData is the network configuration
+	StdinData []byte
+}
+
+// Env returns the environment for a plugin invoked with a
+func (a *Args) Env() []string {
+	env := []string{
+		EnvCommand + "=" + a.Command,
+		EnvContID + "=" + a.ContID,
+		EnvNetNS + "=" + a.NetNS,
+		EnvIfName + "=" + a.IfName,
+		EnvPath + "=" + a.Path,
+		EnvDataDir + "=" + a.DataDir,
+	}
+	// PATH is needed by plugins to find tools like ip(8)
+	return append(env, "PATH="+os.Getenv("PATH"))
+}
+
+// Result is what a plugin reports back on ADD
+type Result struct {
+	// IP is the address assigned to the interface, in CIDR notation
+	IP      string `json:"ip"`
+	Gateway net.IP `json:"gateway,omitempty"`
+	// HostIf is the name of the host side interface, if there is one
+	HostIf string `json:"hostIf,omitempty"`
+}
+
+// IPNet returns the parsed IP of r, with the mask of its subnet
+func (r *Result) IPNet() (*net.IPNet, error) {
+	ip, ipn, err := net.ParseCIDR(r.IP)
+	if err != nil {
+		return nil, fmt.Errorf("bad IP in plugin result: %v", err)
+	}
+	ipn.IP = ip
+	return ipn, nil
+}
+
+// Find looks up the plugin executable named typ in the colon separated list
+// of directories path
+func Find(typ, path string) (string, error) {
+	if typ == "" || strings.Contains(typ, "/") {
+		return "", fmt.Errorf("invalid plugin type %q", typ)
+	}
+	for _, dir := range filepath.SplitList(path) {
+		p := filepath.Join(dir, typ)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("plugin %q not found in %q", typ, path)
+}
+
+// Exec invokes the plugin typ with the given args. On ADD, the returned
+// Result is non-nil.
+func Exec(typ string, args *Args) (*Result, error) {
+	p, err := Find(typ, args.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := &exec.Cmd{
+		Path:   p,
+		Args:   []string{p},
+		Env:    args.Env(),
+		Stdin:  bytes.NewReader(args.StdinData),
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin %s %s failed: %v: %s", typ, args.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	if args.Command != CmdAdd {
+		return nil, nil
+	}
+
+	res := &Result{}
+	if err := json.Unmarshal(stdout.Bytes(), res); err != nil {
+		return nil, fmt.Errorf("error decoding result of plugin %s: %v", typ, err)
+	}
+	return res, nil
+}
+
+// ExecIPAM invokes the IPAM plugin named in the network configuration of
+// args, passing it the same arguments the calling plugin was given
+func ExecIPAM(args *Args) (*Result, error) {
+	conf := NetConf{}
+	if err := json.Unmarshal(args.StdinData, &conf); err != nil {
+		return nil, fmt.Errorf("error decoding network configuration: %v", err)
+	}
+	if conf.IPAM.Type == "" {
+		return nil, fmt.Errorf("network %q does not configure IPAM", conf.Name)
+	}
+	return Exec(conf.IPAM.Type, args)
+}
+
+// ReleaseIPAM invokes the IPAM plugin named in the network configuration of
+// args with DEL, for a plugin failing an ADD to give back the address it got
+func ReleaseIPAM(args *Args) error {
+	del := *args
+	del.Command = CmdDel
+	_, err := ExecIPAM(&del)
+	return err
+}
+
+// Main parses the invocation of a plugin from its environment and stdin and
+// dispatches it to add or del. If add returns a Result it is written to
+// stdout. Main does not return.
+func Main(add func(*Args) (*Result, error), del func(*Args) error) {
+	args, err := argsFromEnv()
+	if err == nil {
+		switch args.Command {
+		case CmdAdd:
+			var res *Result
+			if res, err = add(args); err == nil {
+				err = json.NewEncoder(os.Stdout).Encode(res)
+			}
+		case CmdDel:
+			err = del(args)
+		default:
+			err = fmt.Errorf("unknown command %q", args.Command)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filepath.Base(os.Args[0]), err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func argsFromEnv() (*Args, error) {
+	args := &Args{
+		Command: os.Getenv(EnvCommand),
+		ContID:  os.Getenv(EnvContID),
+		NetNS:   os.Getenv(EnvNetNS),
+		IfName:  os.Getenv(EnvIfName),
+		Path:    os.Getenv(EnvPath),
+		DataDir: os.Getenv(EnvDataDir),
+	}
+	for k, v := range map[string]string{
+		EnvCommand: args.Command,
+		EnvContID:  args.ContID,
+		EnvIfName:  args.IfName,
+	} {
+		if v == "" {
+			return nil, fmt.Errorf("%s must be set", k)
+		}
+	}
+	if args.Command == CmdAdd && args.NetNS == "" {
+		return nil, fmt.Errorf("%s must be set", EnvNetNS)
+	}
+
+	var err error
+	if args.StdinData, err = ioutil.ReadAll(os.Stdin); err != nil {
+		return nil, fmt.Errorf("error reading network configuration: %v", err)
+	}
+	return args, nil
+}
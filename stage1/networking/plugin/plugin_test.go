@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeIPAM writes an IPAM plugin to dir recording its command and container
+// in log, and handing out 10.1.2.3/24 on ADD
+func fakeIPAM(t *testing.T, dir, log string) {
+	script := `#!/bin/sh
+echo "$RKT_NETPLUGIN_COMMAND $RKT_NETPLUGIN_CONTID $RKT_NETPLUGIN_IFNAME" >> ` + log + `
+[ "$RKT_NETPLUGIN_COMMAND" = ADD ] && echo '{"ip": "10.1.2.3/24"}'
+exit 0
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "fake"), []byte(script), 0755); err != nil {
+		t.Fatalf("error writing plugin: %v", err)
+	}
+}
+
+func TestReleaseIPAM(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rkt-plugin")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "log")
+	fakeIPAM(t, dir, log)
+
+	args := &Args{
+		Command:   CmdAdd,
+		ContID:    "c1",
+		NetNS:     "/var/run/netns/c1",
+		IfName:    "eth0",
+		Path:      dir,
+		StdinData: []byte(`{"name": "test", "type": "bridge", "ipam": {"type": "fake"}}`),
+	}
+	res, err := ExecIPAM(args)
+	if err != nil {
+		t.Fatalf("unexpected error from ADD: %v", err)
+	}
+	if res.IP != "10.1.2.3/24" {
+		t.Errorf("unexpected IP %q", res.IP)
+	}
+	if err := ReleaseIPAM(args); err != nil {
+		t.Fatalf("unexpected error from DEL: %v", err)
+	}
+	if args.Command != CmdAdd {
+		t.Errorf("ReleaseIPAM changed the command of args to %q", args.Command)
+	}
+
+	b, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatalf("error reading log: %v", err)
+	}
+	if got, want := strings.TrimSpace(string(b)), "ADD c1 eth0\nDEL c1 eth0"; got != want {
+		t.Errorf("got invocations %q, want %q", got, want)
+	}
+}
+
+func TestExecIPAMWithoutIPAM(t *testing.T) {
+	args := &Args{
+		Command:   CmdDel,
+		StdinData: []byte(`{"name": "test", "type": "bridge"}`),
+	}
+	if err := ReleaseIPAM(args); err == nil {
+		t.Errorf("expected error for network without IPAM")
+	}
+}
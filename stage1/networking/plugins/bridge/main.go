@@ -0,0 +1,148 @@
+package main
+
+// bridge is a network plugin connecting containers to a bridge on the host
+// through veth pairs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/coreos/rocket/stage1/networking/plugin"
+	"github.com/coreos/rocket/stage1/networking/util"
+)
+
+const defaultBrName = "rkt0"
+
+type netConf struct {
+	plugin.NetConf
+	BrName    string `json:"bridge"`
+	IsGateway bool   `json:"isGateway"`
+	IPMasq    bool   `json:"ipMasq"`
+}
+
+func loadConf(b []byte) (*netConf, error) {
+	n := &netConf{BrName: defaultBrName}
+	if err := json.Unmarshal(b, n); err != nil {
+		return nil, fmt.Errorf("error decoding network configuration: %v", err)
+	}
+	return n, nil
+}
+
+// ensureBridge creates the bridge if it does not exist yet and, if gw is not
+// nil, makes sure it carries the gateway address
+func ensureBridge(name string, gw *net.IPNet) error {
+	br, err := net.InterfaceByName(name)
+	if err != nil {
+		if err := util.IP("link", "add", name, "type", "bridge"); err != nil {
+			return err
+		}
+		if br, err = net.InterfaceByName(name); err != nil {
+			return err
+		}
+	}
+
+	if gw != nil {
+		addrs, err := br.Addrs()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, a := range addrs {
+			if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(gw.IP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := util.IP("addr", "add", gw.String(), "dev", name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return util.IP("link", "set", name, "up")
+}
+
+func cmdAdd(args *plugin.Args) (res *plugin.Result, err error) {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = plugin.ExecIPAM(args)
+	if err != nil {
+		return nil, err
+	}
+	// give the address back if the container does not get attached
+	defer func() {
+		if err != nil {
+			plugin.ReleaseIPAM(args)
+		}
+	}()
+	ipn, err := res.IPNet()
+	if err != nil {
+		return nil, err
+	}
+
+	var gw *net.IPNet
+	if n.IsGateway {
+		if res.Gateway == nil {
+			return nil, fmt.Errorf("isGateway set but IPAM returned no gateway")
+		}
+		gw = &net.IPNet{IP: res.Gateway, Mask: ipn.Mask}
+	}
+	if err := ensureBridge(n.BrName, gw); err != nil {
+		return nil, fmt.Errorf("error setting up bridge %q: %v", n.BrName, err)
+	}
+
+	hostIf := util.IfNameFor("veth", args.ContID, args.IfName)
+	tmpIf := util.IfNameFor("vc", args.ContID, args.IfName)
+	if err := util.IP("link", "add", hostIf, "type", "veth", "peer", "name", tmpIf); err != nil {
+		return nil, fmt.Errorf("error creating veth pair: %v", err)
+	}
+	// deleting the host end removes the other one as well, wherever it is
+	defer func() {
+		if err != nil {
+			util.IP("link", "del", hostIf)
+		}
+	}()
+	if err := util.IP("link", "set", hostIf, "master", n.BrName); err != nil {
+		return nil, fmt.Errorf("error attaching veth to bridge: %v", err)
+	}
+	if err := util.IP("link", "set", hostIf, "up"); err != nil {
+		return nil, err
+	}
+
+	var route net.IP
+	if n.IsGateway {
+		route = res.Gateway
+	}
+	if err := util.MoveIntoNS(tmpIf, args.NetNS, args.IfName, ipn, route); err != nil {
+		return nil, fmt.Errorf("error configuring container interface: %v", err)
+	}
+
+	if n.IPMasq {
+		subnet := &net.IPNet{IP: ipn.IP.Mask(ipn.Mask), Mask: ipn.Mask}
+		if err := util.SetupIPMasq(subnet.String(), n.BrName); err != nil {
+			return nil, err
+		}
+	}
+
+	res.HostIf = hostIf
+	return res, nil
+}
+
+func cmdDel(args *plugin.Args) error {
+	if args.NetNS != "" {
+		// deleting one end of the veth pair removes the other as well;
+		// the namespace may already be gone, which is fine
+		util.DelLinkInNS(args.NetNS, args.IfName)
+	}
+	_, err := plugin.ExecIPAM(args)
+	return err
+}
+
+func main() {
+	plugin.Main(cmdAdd, cmdDel)
+}
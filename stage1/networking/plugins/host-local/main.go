@@ -0,0 +1,80 @@
+package main
+
+// host-local is an IPAM plugin allocating addresses from a subnet, keeping
+// its leases in files on the local host
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"github.com/coreos/rocket/stage1/networking/ipam"
+	"github.com/coreos/rocket/stage1/networking/plugin"
+)
+
+type ipamConf struct {
+	Name string `json:"name"`
+	IPAM struct {
+		Subnet  string `json:"subnet"`
+		Gateway net.IP `json:"gateway"`
+		// DataDir overrides where leases are kept
+		DataDir string `json:"dataDir"`
+	} `json:"ipam"`
+}
+
+func loadConf(args *plugin.Args) (*ipamConf, string, error) {
+	c := &ipamConf{}
+	if err := json.Unmarshal(args.StdinData, c); err != nil {
+		return nil, "", fmt.Errorf("error decoding network configuration: %v", err)
+	}
+	if c.Name == "" {
+		return nil, "", fmt.Errorf("network name missing")
+	}
+	dir := c.IPAM.DataDir
+	if dir == "" {
+		if args.DataDir == "" {
+			return nil, "", fmt.Errorf("no directory to keep leases in")
+		}
+		dir = filepath.Join(args.DataDir, "leases", c.Name)
+	}
+	return c, dir, nil
+}
+
+func cmdAdd(args *plugin.Args) (*plugin.Result, error) {
+	c, dir, err := loadConf(args)
+	if err != nil {
+		return nil, err
+	}
+	_, subnet, err := net.ParseCIDR(c.IPAM.Subnet)
+	if err != nil {
+		return nil, fmt.Errorf("bad subnet %q: %v", c.IPAM.Subnet, err)
+	}
+
+	ipa, err := ipam.NewIPAllocator(dir, subnet, c.IPAM.Gateway)
+	if err != nil {
+		return nil, err
+	}
+	ip, err := ipa.Allocate(args.ContID + "/" + args.IfName)
+	if err != nil {
+		return nil, err
+	}
+
+	ipn := net.IPNet{IP: ip, Mask: subnet.Mask}
+	return &plugin.Result{
+		IP:      ipn.String(),
+		Gateway: ipa.Gateway(),
+	}, nil
+}
+
+func cmdDel(args *plugin.Args) error {
+	_, dir, err := loadConf(args)
+	if err != nil {
+		return err
+	}
+	return ipam.NewReleaser(dir).Release(args.ContID + "/" + args.IfName)
+}
+
+func main() {
+	plugin.Main(cmdAdd, cmdDel)
+}
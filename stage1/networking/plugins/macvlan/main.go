@@ -0,0 +1,83 @@
+package main
+
+// macvlan is a network plugin giving containers a macvlan interface on top
+// of a host interface
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/coreos/rocket/stage1/networking/plugin"
+	"github.com/coreos/rocket/stage1/networking/util"
+)
+
+type netConf struct {
+	plugin.NetConf
+	Master string `json:"master"`
+	Mode   string `json:"mode"`
+}
+
+func loadConf(b []byte) (*netConf, error) {
+	n := &netConf{Mode: "bridge"}
+	if err := json.Unmarshal(b, n); err != nil {
+		return nil, fmt.Errorf("error decoding network configuration: %v", err)
+	}
+	if n.Master == "" {
+		return nil, fmt.Errorf(`"master" field is required; it specifies the host interface name to virtualize`)
+	}
+	switch n.Mode {
+	case "bridge", "private", "vepa", "passthru":
+	default:
+		return nil, fmt.Errorf("unknown macvlan mode %q", n.Mode)
+	}
+	return n, nil
+}
+
+func cmdAdd(args *plugin.Args) (res *plugin.Result, err error) {
+	n, err := loadConf(args.StdinData)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err = plugin.ExecIPAM(args)
+	if err != nil {
+		return nil, err
+	}
+	// give the address back if the container does not get attached
+	defer func() {
+		if err != nil {
+			plugin.ReleaseIPAM(args)
+		}
+	}()
+	ipn, err := res.IPNet()
+	if err != nil {
+		return nil, err
+	}
+
+	tmpIf := util.IfNameFor("mv", args.ContID, args.IfName)
+	if err := util.IP("link", "add", tmpIf, "link", n.Master, "type", "macvlan", "mode", n.Mode); err != nil {
+		return nil, fmt.Errorf("error creating macvlan interface: %v", err)
+	}
+	if err := util.MoveIntoNS(tmpIf, args.NetNS, args.IfName, ipn, res.Gateway); err != nil {
+		// the interface is either still on the host or already in the
+		// container, possibly renamed
+		util.IP("link", "del", tmpIf)
+		util.DelLinkInNS(args.NetNS, tmpIf)
+		util.DelLinkInNS(args.NetNS, args.IfName)
+		return nil, fmt.Errorf("error configuring container interface: %v", err)
+	}
+
+	return res, nil
+}
+
+func cmdDel(args *plugin.Args) error {
+	if args.NetNS != "" {
+		util.DelLinkInNS(args.NetNS, args.IfName)
+	}
+	_, err := plugin.ExecIPAM(args)
+	return err
+}
+
+func main() {
+	plugin.Main(cmdAdd, cmdDel)
+}
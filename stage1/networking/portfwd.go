@@ -0,0 +1,92 @@
+package networking
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/coreos/rocket/stage1/networking/util"
+)
+
+// ForwardedPort describes a port of the container published on the host
+type ForwardedPort struct {
+	Protocol string // tcp or udp
+	HostPort uint
+	PodPort  uint
+}
+
+// ForwardPorts sets up DNAT rules forwarding the given host ports to the
+// container's address on its first network. The rules live in a chain of
+// their own, which Teardown removes.
+func (n *Networking) ForwardPorts(fps []ForwardedPort) error {
+	if len(fps) == 0 {
+		return nil
+	}
+	if len(n.Nets) == 0 {
+		return fmt.Errorf("container has no network to forward ports to")
+	}
+	ipn, err := n.Nets[0].Result.IPNet()
+	if err != nil {
+		return err
+	}
+
+	chain := n.portFwdChain()
+	if err := iptables("-N", chain); err != nil {
+		return err
+	}
+	n.forwarding = true
+	for _, jump := range portFwdJumps(chain) {
+		if err := iptables(append([]string{"-A"}, jump...)...); err != nil {
+			return err
+		}
+	}
+	for _, fp := range fps {
+		dest := net.JoinHostPort(ipn.IP.String(), fmt.Sprint(fp.PodPort))
+		err := iptables("-A", chain, "-p", fp.Protocol, "--dport", fmt.Sprint(fp.HostPort), "-j", "DNAT", "--to-destination", dest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unforwardPorts removes the rules installed by ForwardPorts
+func (n *Networking) unforwardPorts() error {
+	if !n.forwarding {
+		return nil
+	}
+	chain := n.portFwdChain()
+	var errs []string
+	for _, jump := range portFwdJumps(chain) {
+		if err := iptables(append([]string{"-D"}, jump...)...); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	for _, op := range []string{"-F", "-X"} {
+		if err := iptables(op, chain); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error removing port forwarding: %s", strings.Join(errs, "; "))
+	}
+	n.forwarding = false
+	return nil
+}
+
+func (n *Networking) portFwdChain() string {
+	return "RKT-" + strings.ToUpper(strings.Replace(n.ContainerID.String(), "-", "", -1)[:16])
+}
+
+// portFwdJumps returns the rules sending traffic destined to the host (from
+// outside or from the host itself) through chain
+func portFwdJumps(chain string) [][]string {
+	return [][]string{
+		{"PREROUTING", "-m", "addrtype", "--dst-type", "LOCAL", "-j", chain},
+		{"OUTPUT", "-m", "addrtype", "--dst-type", "LOCAL", "-j", chain},
+	}
+}
+
+func iptables(args ...string) error {
+	return util.Run("iptables", append([]string{"-t", "nat"}, args...)...)
+}
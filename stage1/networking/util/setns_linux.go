@@ -0,0 +1,8 @@
+//go:build !amd64 && !386
+// +build !amd64,!386
+
+package util
+
+import "syscall"
+
+const sysSetns = syscall.SYS_SETNS
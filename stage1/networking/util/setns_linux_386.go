@@ -0,0 +1,4 @@
+package util
+
+// package syscall does not define SYS_SETNS on 386
+const sysSetns = 346
@@ -0,0 +1,4 @@
+package util
+
+// package syscall does not define SYS_SETNS on amd64
+const sysSetns = 308
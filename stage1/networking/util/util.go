@@ -0,0 +1,136 @@
+package util
+
+//
+// Helpers shared by the networking runtime in stage1 and the network plugins
+//
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// WithNetNS calls f with the calling thread switched into the network
+// namespace at nspath, switching back once f returns. Processes started by f
+// inherit the network namespace.
+func WithNetNS(nspath string, f func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	curNS, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid()))
+	if err != nil {
+		return fmt.Errorf("error opening current network namespace: %v", err)
+	}
+	defer curNS.Close()
+
+	ns, err := os.Open(nspath)
+	if err != nil {
+		return fmt.Errorf("error opening network namespace %q: %v", nspath, err)
+	}
+	defer ns.Close()
+
+	if err := setns(ns.Fd()); err != nil {
+		return fmt.Errorf("error entering network namespace %q: %v", nspath, err)
+	}
+	ferr := f()
+	if err := setns(curNS.Fd()); err != nil {
+		// the thread is unusable; let the runtime get rid of it
+		panic(fmt.Sprintf("error returning to original network namespace: %v", err))
+	}
+	return ferr
+}
+
+func setns(fd uintptr) error {
+	if _, _, errno := syscall.RawSyscall(sysSetns, fd, syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IP runs the ip(8) command with the given arguments
+func IP(args ...string) error {
+	return Run("ip", args...)
+}
+
+// Run runs the given command, returning an error including its output if it
+// fails
+func Run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// SetupIPMasq makes sure traffic from subnet leaving through any interface
+// but ifName is masqueraded
+func SetupIPMasq(subnet, ifName string) error {
+	if err := enableIPForward(); err != nil {
+		return err
+	}
+	rule := []string{"POSTROUTING", "-s", subnet, "!", "-o", ifName, "-j", "MASQUERADE"}
+	if exec.Command("iptables", append([]string{"-t", "nat", "-C"}, rule...)...).Run() == nil {
+		return nil
+	}
+	return Run("iptables", append([]string{"-t", "nat", "-A"}, rule...)...)
+}
+
+func enableIPForward() error {
+	f, err := os.OpenFile("/proc/sys/net/ipv4/ip_forward", os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error enabling IP forwarding: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString("1"); err != nil {
+		return fmt.Errorf("error enabling IP forwarding: %v", err)
+	}
+	return nil
+}
+
+// MoveIntoNS moves the host interface tmpName into the network namespace at
+// nspath and sets it up there as ifName with address ipn. If gw is not nil a
+// default route through it is added.
+func MoveIntoNS(tmpName, nspath, ifName string, ipn *net.IPNet, gw net.IP) error {
+	if err := IP("link", "set", tmpName, "netns", nspath); err != nil {
+		return err
+	}
+	return WithNetNS(nspath, func() error {
+		cmds := [][]string{
+			{"link", "set", "lo", "up"},
+			{"link", "set", tmpName, "name", ifName},
+			{"addr", "add", ipn.String(), "dev", ifName},
+			{"link", "set", ifName, "up"},
+		}
+		if gw != nil {
+			cmds = append(cmds, []string{"route", "add", "default", "via", gw.String(), "dev", ifName})
+		}
+		for _, c := range cmds {
+			if err := IP(c...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DelLinkInNS deletes the interface ifName inside the network namespace at
+// nspath
+func DelLinkInNS(nspath, ifName string) error {
+	return WithNetNS(nspath, func() error {
+		return IP("link", "del", ifName)
+	})
+}
+
+// IfNameFor derives an interface name with the given prefix, unique for the
+// given container and interface within it, short enough for the kernel's
+// limit of 15 characters
+func IfNameFor(prefix, contID, ifName string) string {
+	h := sha1.Sum([]byte(contID + ifName))
+	return prefix + hex.EncodeToString(h[:])[:15-len(prefix)]
+}
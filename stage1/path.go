@@ -13,9 +13,55 @@ const (
 )
 
 // ServiceName returns a sanitized (escaped) systemd service name
-// for the given imageID
-func ServiceName(imageID types.Hash) string {
-	return imageID.String() + ".service"
+// for the given app
+func ServiceName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ".service"
+}
+
+// SocketName returns a sanitized (escaped) systemd socket name
+// for the given app
+func SocketName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ".socket"
+}
+
+// HealthCheckServiceName returns the name of the systemd service running the
+// health-check handler of the given app. App names cannot contain ":", so
+// this cannot clash with the service of another app.
+func HealthCheckServiceName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ":health-check.service"
+}
+
+// HealthCheckTimerName returns the name of the systemd timer periodically
+// starting the health-check service of the given app
+func HealthCheckTimerName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ":health-check.timer"
+}
+
+// HealthFailedServiceName returns the name of the systemd service applying
+// the health-check failure policy of the given app
+func HealthFailedServiceName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ":health-failed.service"
+}
+
+// NotifySocketServiceName returns the name of the systemd service waiting for
+// the notification socket of systemd to show up in the rootfs of the given
+// app, which notifies its readiness
+func NotifySocketServiceName(appName types.ACName) string {
+	return path.EscapedAppName(appName) + ":notify-socket.service"
+}
+
+// SystemdRunPath returns the directory in root bind mounted on the
+// /run/systemd of stage1 when apps notify their readiness, where systemd
+// creates its notification socket
+func SystemdRunPath(root string) string {
+	return filepath.Join(root, "notify", "systemd")
+}
+
+// AppNotifyDirPath returns the directory in root bind mounted on the
+// /run/systemd of the given app, which notifies its readiness. It only holds
+// a link to the notification socket of systemd.
+func AppNotifyDirPath(root string, appName types.ACName) string {
+	return filepath.Join(root, "notify", "apps", path.EscapedAppName(appName))
 }
 
 // WantsPath returns the systemd "wants" directory in root
@@ -29,13 +75,30 @@ func ServicesPath(root string) string {
 }
 
 // ServiceFilePath returns the path to the systemd service file
-// path for the given imageID
-func ServiceFilePath(root string, imageID types.Hash) string {
-	return filepath.Join(root, servicesDir, ServiceName(imageID))
+// path for the given app
+func ServiceFilePath(root string, appName types.ACName) string {
+	return filepath.Join(root, servicesDir, ServiceName(appName))
+}
+
+// UnitFilePath returns the path to the systemd unit file of the given name
+func UnitFilePath(root string, unitName string) string {
+	return filepath.Join(root, servicesDir, unitName)
 }
 
 // WantLinkPath returns the systemd "want" symlink path for the
-// given imageID
-func WantLinkPath(root string, imageID types.Hash) string {
-	return filepath.Join(root, wantsDir, ServiceName(imageID))
+// given app
+func WantLinkPath(root string, appName types.ACName) string {
+	return filepath.Join(root, wantsDir, ServiceName(appName))
+}
+
+// SocketFilePath returns the path to the systemd socket file
+// path for the given app
+func SocketFilePath(root string, appName types.ACName) string {
+	return filepath.Join(root, servicesDir, SocketName(appName))
+}
+
+// SocketWantLinkPath returns the systemd "want" symlink path for the
+// socket of the given app
+func SocketWantLinkPath(root string, appName types.ACName) string {
+	return filepath.Join(root, wantsDir, SocketName(appName))
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/rocket/app-container/schema/types"
+	"github.com/coreos/rocket/pkg/portfwd"
+	"github.com/coreos/rocket/stage1/networking"
+)
+
+// portRequests implements the flag.Value interface for the --port flag,
+// which takes the same NAME:HOSTPORT form as `rkt run --port`
+type portRequests []portfwd.Request
+
+func (pr *portRequests) Set(s string) error {
+	r, err := portfwd.Parse(s)
+	if err != nil {
+		return err
+	}
+	*pr = append(*pr, *r)
+	return nil
+}
+
+func (pr *portRequests) String() string {
+	var ss []string
+	for _, r := range *pr {
+		ss = append(ss, r.String())
+	}
+	return strings.Join(ss, ",")
+}
+
+// resolvePorts looks up the requested ports among the ports declared by the
+// container's apps
+func (c *Container) resolvePorts(reqs portRequests) ([]networking.ForwardedPort, error) {
+	var fps []networking.ForwardedPort
+	for _, r := range reqs {
+		var found *types.Port
+		for _, am := range c.Apps {
+			for i, p := range am.Ports {
+				if p.Name.Equals(r.Name) {
+					found = &am.Ports[i]
+				}
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown port %q", r.Name)
+		}
+		fps = append(fps, networking.ForwardedPort{
+			Protocol: found.Protocol,
+			HostPort: r.HostPort,
+			PodPort:  found.Port,
+		})
+	}
+	return fps, nil
+}
@@ -0,0 +1,144 @@
+//go:build functional
+// +build functional
+
+package tests
+
+//
+// Functional tests, running the rkt binaries built by ./build. They need root
+// privileges, and systemd-nspawn, iptables and ebtables on the host, and are
+// only built with the "functional" build tag:
+//
+//	sudo -E go test -tags functional ./tests/
+//
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rktpath "github.com/coreos/rocket/path"
+)
+
+const (
+	binDir = "../bin"
+	aceDir = "../app-container/ace"
+
+	// mainAppName is the name of the app of the main validator ACI
+	mainAppName = "coreos.com/ace-validator-main-1.0.0"
+)
+
+// buildValidatorACI builds the ACE validator ACI of the given type (main or
+// sidekick) into dir, and returns its path
+func buildValidatorACI(t *testing.T, dir, typ string) string {
+	rootfs := filepath.Join(dir, typ, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		t.Fatalf("error creating rootfs: %v", err)
+	}
+	copyFile(t, filepath.Join(binDir, "ace-validator"), filepath.Join(rootfs, "ace-validator"), 0755)
+
+	aci := filepath.Join(dir, "ace-validator-"+typ+".aci")
+	manifest := filepath.Join(aceDir, "app_manifest_"+typ+".json")
+	cmd := exec.Command(filepath.Join(binDir, "actool"), "build", "--app-manifest", manifest, rootfs, aci)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("error building %s ACI: %v\n%s", typ, err, out)
+	}
+	return aci
+}
+
+func copyFile(t *testing.T, src, dst string, mode os.FileMode) {
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("error opening %s (did you run ./build?): %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		t.Fatalf("error creating %s: %v", dst, err)
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		t.Fatalf("error copying %s: %v", src, err)
+	}
+}
+
+// startMetadataSvc starts the metadata service built by ./build and waits for
+// it to accept registrations. The returned command is to be killed once done.
+func startMetadataSvc(t *testing.T) *exec.Cmd {
+	if _, err := os.Stat(rktpath.MetadataSvcRegSock); err == nil {
+		t.Fatalf("%s exists: is a metadata service already running?", rktpath.MetadataSvcRegSock)
+	}
+	cmd := exec.Command(filepath.Join(binDir, "metadatasvc"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("error starting metadata service (did you run ./build?): %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(rktpath.MetadataSvcRegSock); err == nil {
+			return cmd
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	t.Fatalf("metadata service did not start")
+	return nil
+}
+
+// TestACEValidator runs the ACE validator apps in a container with the
+// default (systemd) stage1, in a private network for the apps to reach the
+// metadata service
+func TestACEValidator(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("functional tests need root privileges")
+	}
+
+	dir, err := ioutil.TempDir("", "rkt-functional")
+	if err != nil {
+		t.Fatalf("error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := buildValidatorACI(t, dir, "main")
+	sidekick := buildValidatorACI(t, dir, "sidekick")
+	db := filepath.Join(dir, "db")
+	if err := os.Mkdir(db, 0755); err != nil {
+		t.Fatalf("error creating volume: %v", err)
+	}
+
+	svc := startMetadataSvc(t)
+	defer func() {
+		svc.Process.Kill()
+		svc.Wait()
+		os.Remove(rktpath.MetadataSvcRegSock)
+	}()
+
+	var out bytes.Buffer
+	cmd := exec.Command(filepath.Join(binDir, "rkt"), "--dir="+filepath.Join(dir, "rkt"),
+		"run", "--private-net", "--volume=database:"+db, main, sidekick, "--after-ready="+mainAppName)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	runErr := cmd.Run()
+
+	var failures []string
+	for _, l := range strings.Split(out.String(), "\n") {
+		if strings.Contains(l, "==>") {
+			failures = append(failures, l)
+		}
+	}
+	if len(failures) > 0 {
+		t.Errorf("validation failed:\n%s", strings.Join(failures, "\n"))
+	}
+	for _, want := range []string{"prestart OK", "poststart OK", "healthcheck OK", "sidekick OK", "prestop OK", "poststop OK"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in output", want)
+		}
+	}
+	if t.Failed() {
+		t.Logf("rkt run: %v, output:\n%s", runErr, out.String())
+	}
+}